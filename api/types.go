@@ -1,23 +1,48 @@
 package api
 
+// Error is sent to the browser if an action or page request failed.
 type Error struct {
-	Code    string
-	Message string
+	Code    string // machine readable error code like "invalidArgs"
+	Message string // human readable error message
+	// Fields maps the names of invalid form fields to their error messages.
+	Fields map[string]string `json:",omitempty"`
+	// Details can contain any additional information about the error.
+	Details any `json:",omitempty"`
+	// Retryable is set if the same request might succeed if it is retried.
+	Retryable bool `json:",omitempty"`
 }
 
 type HTMLOp int8
 
 const (
-	HTMLReplaceContent HTMLOp = 1
-	HTMLReplaceElement HTMLOp = 2
-	HTMLInsertBefore   HTMLOp = 3
-	HTMLInsertAfter    HTMLOp = 4
+	HTMLReplaceContent  HTMLOp = 1
+	HTMLReplaceElement  HTMLOp = 2
+	HTMLInsertBefore    HTMLOp = 3
+	HTMLInsertAfter     HTMLOp = 4
+	HTMLRemove          HTMLOp = 5
+	HTMLAppend          HTMLOp = 6
+	HTMLPrepend         HTMLOp = 7
+	HTMLSetAttribute    HTMLOp = 8
+	HTMLRemoveAttribute HTMLOp = 9
+	HTMLAddClass        HTMLOp = 10
+	HTMLRemoveClass     HTMLOp = 11
+	HTMLToggleClass     HTMLOp = 12
+	HTMLSetValue        HTMLOp = 13
+	HTMLMorph           HTMLOp = 14
 )
 
 type HTMLUpdate struct {
 	Operation HTMLOp // how to apply this update
 	Selector  string // querySelector syntax: #id .class
-	Content   string `json:",omitempty"`
+	// Name is the attribute name or CSS class for the attribute and class operations
+	Name    string `json:",omitempty"`
+	Content string `json:",omitempty"` // HTML content, attribute value or input value
+	// All applies the update to all elements that match the selector,
+	// instead of only the first one.
+	All bool `json:",omitempty"`
+	// Required reports an error to the server if no element matches the
+	// selector, instead of only logging a warning in the browser.
+	Required bool `json:",omitempty"`
 }
 
 type JSCall struct {
@@ -31,3 +56,25 @@ type Stream struct {
 	// Args as object, gets encoded by the called function
 	Args any `json:",omitempty"`
 }
+
+// NavigationOp is the type of navigation that the browser
+// does after the rest of an Update was applied.
+type NavigationOp int8
+
+const (
+	NavigationLoad       NavigationOp = 1 // load the guiapi page and push it to the history
+	NavigationReplace    NavigationOp = 2 // load the guiapi page and replace the current history entry
+	NavigationReplaceURL NavigationOp = 3 // only replace the URL of the current history entry
+	NavigationRedirect   NavigationOp = 4 // load the URL as a full page
+	NavigationReload     NavigationOp = 5 // reload the current page as a full page
+)
+
+type Navigation struct {
+	Operation NavigationOp // how to navigate
+	URL       string       `json:",omitempty"` // not used for NavigationReload
+}
+
+type MetaTag struct {
+	Name    string // name attribute of the meta tag in the document head
+	Content string // content attribute, the tag is created if it doesn't exist
+}
@@ -0,0 +1,64 @@
+package guiapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+)
+
+// StreamAuthorizer decides if a stream may be started for a request. It is
+// called with the PageCtx of the WebSocket or Server-Sent Events request and
+// the name and arguments of the stream, before the StreamFunc is started.
+// If it returns an error, the stream is denied.
+type StreamAuthorizer func(c *PageCtx, name string, args json.RawMessage) error
+
+// errForbidden is returned when a StreamAuthorizer denied a stream.
+// The error of the StreamAuthorizer is only logged, not sent to the browser.
+var errForbidden = errors.New("access to the stream is forbidden")
+
+// authorizeStream calls the StreamAuthorizer, if one is set. A panic in
+// the StreamAuthorizer is recovered and reported, and denies the stream.
+func (s *Server) authorizeStream(c *PageCtx, name string, args json.RawMessage) error {
+	if s.opts.StreamAuthorizer == nil {
+		return nil
+	}
+	err := s.safely(c.Request, fmt.Sprintf("stream %q authorizer", name), func() error {
+		return s.opts.StreamAuthorizer(c, name, args)
+	})
+	if err != nil {
+		s.logger.Warn("stream denied", "stream", name, "error", err)
+		return errForbidden
+	}
+	return nil
+}
+
+// checkOrigin returns an error if the Origin header of the request is set
+// and neither matches the host of the request nor Options.OriginPatterns.
+// It matches the patterns the same way as the WebSocket origin check.
+func (s *Server) checkOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return fmt.Errorf("invalid origin %q: %w", origin, err)
+	}
+	if strings.EqualFold(r.Host, u.Host) {
+		return nil
+	}
+	for _, pattern := range s.opts.OriginPatterns {
+		matched, err := filepath.Match(strings.ToLower(pattern), strings.ToLower(u.Host))
+		if err != nil {
+			return fmt.Errorf("invalid origin pattern %q: %w", pattern, err)
+		}
+		if matched {
+			return nil
+		}
+	}
+	return fmt.Errorf("origin %q is not allowed for host %q", origin, r.Host)
+}
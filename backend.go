@@ -0,0 +1,63 @@
+package guiapi
+
+import "sync"
+
+// Backend distributes the Updates that are published on a Hub to the Hubs
+// of all server instances, so that streams can be scaled horizontally.
+// A Backend has to deliver every published Update to the handlers of all
+// instances, including the instance that published the Update.
+//
+// See the cluster package for a Backend that works over TCP.
+type Backend interface {
+	// Publish sends the Update for the topic to all instances.
+	Publish(topic string, u *Update) error
+	// Listen registers the handler that gets called for every
+	// Update that was published by any of the instances.
+	Listen(handler func(topic string, u *Update)) error
+}
+
+// SetBackend sets the Backend that is used to distribute published Updates
+// between server instances. Without a Backend, the Hub only delivers Updates
+// to subscribers of the same instance.
+func (h *Hub) SetBackend(b Backend) error {
+	err := b.Listen(h.deliver)
+	if err != nil {
+		return err
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.backend = b
+	return nil
+}
+
+// MemoryBackend is a Backend that distributes Updates between Hubs in the
+// same process. It is mainly useful for testing multi instance setups.
+type MemoryBackend struct {
+	lock     sync.Mutex
+	handlers []func(topic string, u *Update)
+}
+
+// NewMemoryBackend returns a new MemoryBackend.
+// The same MemoryBackend should be set on all Hubs.
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{}
+}
+
+// Publish delivers the Update to the handlers of all Hubs.
+func (m *MemoryBackend) Publish(topic string, u *Update) error {
+	m.lock.Lock()
+	handlers := m.handlers
+	m.lock.Unlock()
+	for _, handler := range handlers {
+		handler(topic, u)
+	}
+	return nil
+}
+
+// Listen registers the handler of a Hub.
+func (m *MemoryBackend) Listen(handler func(topic string, u *Update)) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.handlers = append(m.handlers, handler)
+	return nil
+}
@@ -0,0 +1,66 @@
+package guiapi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/mbertschler/guiapi/api"
+)
+
+// ClientError is an error that happened in the browser and was reported
+// to the server, so that it gets passed to the ErrorReporter. The browser
+// reports an error with the code "missingTarget" if no element matches the
+// selector of a HTML update that has Required set.
+type ClientError struct {
+	Code      string     // kind of the error, for example "missingTarget"
+	Message   string     // description of the error
+	Selector  string     `json:",omitempty"` // selector of the HTML update
+	Operation api.HTMLOp `json:",omitempty"` // operation of the HTML update
+	Page      string     `json:",omitempty"` // URL of the page in the browser
+}
+
+func (e *ClientError) Error() string {
+	if e.Selector != "" {
+		return fmt.Sprintf("client error %s: %s (selector %q)", e.Code, e.Message, e.Selector)
+	}
+	return fmt.Sprintf("client error %s: %s", e.Code, e.Message)
+}
+
+// clientErrorHandler receives the errors that the browser reports and
+// passes them to the ErrorReporter. The request needs to pass the same
+// CSRF check as actions, so that other sites can't fill the error logs.
+func (s *Server) clientErrorHandler(c *PageCtx) {
+	err := s.checkCSRF(c.Request)
+	if err != nil {
+		s.rejectCSRF(c)
+		return
+	}
+	body := c.Request.Body
+	if s.opts.MaxBodySize > 0 {
+		body = http.MaxBytesReader(c.Writer, body, s.opts.MaxBodySize)
+	}
+	buf, err := io.ReadAll(body)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			s.writeError(c.Writer, errTooLarge)
+			return
+		}
+		s.writeError(c.Writer, fmt.Errorf("%w: %v", errInvalidRequest, err))
+		return
+	}
+	var clientErr ClientError
+	err = checkJSONDepth(buf, s.opts.MaxJSONDepth)
+	if err == nil {
+		err = json.Unmarshal(buf, &clientErr)
+	}
+	if err != nil || clientErr.Code == "" {
+		s.writeError(c.Writer, fmt.Errorf("%w: invalid client error", errInvalidRequest))
+		return
+	}
+	s.reportError(c.Request, &clientErr)
+	c.Writer.WriteHeader(http.StatusNoContent)
+}
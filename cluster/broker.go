@@ -0,0 +1,124 @@
+package cluster
+
+import (
+	"bufio"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+)
+
+// writeTimeout is the time after which a connection to a TCPBackend
+// that doesn't accept any more messages is closed by the Broker.
+const writeTimeout = 5 * time.Second
+
+// Broker relays all messages that are published by any connected
+// TCPBackend to all connected TCPBackends. Only TCPBackends that
+// know the shared secret of the Broker are accepted.
+type Broker struct {
+	secret string
+	logger *slog.Logger
+
+	lock    sync.Mutex
+	clients map[net.Conn]struct{}
+}
+
+// NewBroker returns a new Broker without any connections, which
+// accepts TCPBackends that were created with the same secret.
+func NewBroker(secret string) *Broker {
+	return &Broker{
+		secret:  secret,
+		logger:  slog.Default(),
+		clients: map[net.Conn]struct{}{},
+	}
+}
+
+// SetLogger sets the logger for connection errors, which is
+// slog.Default() if it isn't set. It has to be called before Serve().
+func (b *Broker) SetLogger(logger *slog.Logger) {
+	b.logger = logger
+}
+
+// ListenAndServe listens on the TCP address and serves a new Broker
+// with the shared secret.
+func ListenAndServe(addr, secret string) error {
+	if secret == "" {
+		return ErrNoSecret
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	return NewBroker(secret).Serve(l)
+}
+
+// Serve accepts connections from TCPBackends on the listener until
+// it is closed. It always returns a non-nil error, and ErrNoSecret
+// if the Broker has an empty secret.
+func (b *Broker) Serve(l net.Listener) error {
+	if b.secret == "" {
+		return ErrNoSecret
+	}
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return err
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *Broker) handle(conn net.Conn) {
+	reader := bufio.NewReader(conn)
+	err := acceptHandshake(conn, reader, b.secret)
+	if err != nil {
+		b.logger.Warn("cluster broker: handshake failed", "remote", conn.RemoteAddr().String(), "error", err)
+		conn.Close()
+		return
+	}
+	// the connection is registered before the TCPBackend gets the OK,
+	// so that it receives all Updates that are published afterwards
+	b.lock.Lock()
+	conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err = conn.Write([]byte(handshakeOK + "\n"))
+	if err == nil {
+		b.clients[conn] = struct{}{}
+	}
+	b.lock.Unlock()
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer b.remove(conn)
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, MaxMessageSize)
+	for scanner.Scan() {
+		line := append(scanner.Bytes(), '\n')
+		b.broadcast(line)
+	}
+	if err := scanner.Err(); err != nil {
+		b.logger.Warn("cluster broker: read error", "error", err)
+	}
+}
+
+func (b *Broker) broadcast(line []byte) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for conn := range b.clients {
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		_, err := conn.Write(line)
+		if err != nil {
+			b.logger.Warn("cluster broker: write error", "error", err)
+			delete(b.clients, conn)
+			conn.Close()
+		}
+	}
+}
+
+func (b *Broker) remove(conn net.Conn) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.clients, conn)
+	conn.Close()
+}
@@ -0,0 +1,139 @@
+// Package cluster contains a guiapi.Backend that distributes stream Updates
+// between multiple server instances over TCP.
+//
+// All instances connect to a single Broker with a TCPBackend. The Broker
+// relays every published Update to all connected instances, including the
+// one that published it. A Broker can run in its own process or inside one
+// of the instances.
+//
+//	go cluster.ListenAndServe("localhost:7070", secret)
+//
+//	backend := cluster.NewTCPBackend("localhost:7070", secret)
+//	err := server.Hub().SetBackend(backend)
+//
+// Every Update that reaches the Broker is sent to all browsers that follow
+// the topic, including its HTML and JS calls. Connections therefore have to
+// prove that they know the shared secret before the Broker accepts them. The
+// secret is not sent over the connection, but the Updates are not encrypted,
+// so the Broker should only listen on a private network or behind a TLS
+// tunnel, and the secret should be long and random.
+package cluster
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mbertschler/guiapi"
+)
+
+// MaxMessageSize is the maximum size of a single encoded Update
+// that can be sent between the Broker and TCPBackends.
+const MaxMessageSize = 4 << 20
+
+const (
+	// handshakeTimeout is the time that both sides have to finish the handshake.
+	handshakeTimeout = 5 * time.Second
+	// handshakeOK is sent by the Broker after a successful handshake.
+	handshakeOK = "ok"
+	nonceSize   = 32
+)
+
+var (
+	// ErrNoSecret is returned if a Broker or TCPBackend has an empty secret.
+	ErrNoSecret = errors.New("cluster: the shared secret must not be empty")
+	// ErrAuthFailed is returned if the Broker rejected the secret of a TCPBackend.
+	ErrAuthFailed = errors.New("cluster: authentication with the broker failed")
+)
+
+// message is sent between the Broker and TCPBackends as a single
+// line of JSON.
+type message struct {
+	Topic  string
+	Update *guiapi.Update
+}
+
+func encodeMessage(topic string, u *guiapi.Update) ([]byte, error) {
+	buf, err := json.Marshal(message{Topic: topic, Update: u})
+	if err != nil {
+		return nil, err
+	}
+	return append(buf, '\n'), nil
+}
+
+// authResponse returns the proof that the secret is known for the nonce.
+func authResponse(secret, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(nonce))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// acceptHandshake sends a random nonce to the connection and checks that
+// the response was created with the secret. It is run by the Broker, which
+// sends handshakeOK once the connection is registered.
+func acceptHandshake(conn net.Conn, r *bufio.Reader, secret string) error {
+	buf := make([]byte, nonceSize)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return err
+	}
+	nonce := hex.EncodeToString(buf)
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	_, err = conn.Write([]byte(nonce + "\n"))
+	if err != nil {
+		return err
+	}
+	response, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal([]byte(response), []byte(authResponse(secret, nonce))) {
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+// dialHandshake answers the nonce of the Broker with the secret.
+// It is run by the TCPBackend.
+func dialHandshake(conn net.Conn, r *bufio.Reader, secret string) error {
+	conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	defer conn.SetDeadline(time.Time{})
+	nonce, err := readLine(r)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write([]byte(authResponse(secret, nonce) + "\n"))
+	if err != nil {
+		return err
+	}
+	ok, err := readLine(r)
+	if err != nil {
+		// the Broker closes the connection if the secret is wrong
+		return fmt.Errorf("%w: %v", ErrAuthFailed, err)
+	}
+	if ok != handshakeOK {
+		return ErrAuthFailed
+	}
+	return nil
+}
+
+// readLine reads a short line of the handshake.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) > 2*nonceSize+2 {
+		return "", errors.New("cluster: handshake line too long")
+	}
+	return strings.TrimSpace(line), nil
+}
@@ -0,0 +1,102 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mbertschler/guiapi"
+)
+
+const testSecret = "test secret"
+
+// startBroker runs a Broker on a random local port and returns its address.
+func startBroker(t *testing.T) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go NewBroker(testSecret).Serve(l)
+	return l.Addr().String()
+}
+
+func TestBackends(t *testing.T) {
+	cases := []struct {
+		name     string
+		backends func(t *testing.T) (guiapi.Backend, guiapi.Backend)
+	}{
+		{"memory", func(t *testing.T) (guiapi.Backend, guiapi.Backend) {
+			backend := guiapi.NewMemoryBackend()
+			return backend, backend
+		}},
+		{"tcp", func(t *testing.T) (guiapi.Backend, guiapi.Backend) {
+			addr := startBroker(t)
+			a, b := NewTCPBackend(addr, testSecret), NewTCPBackend(addr, testSecret)
+			t.Cleanup(func() { a.Close(); b.Close() })
+			return a, b
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			backendA, backendB := c.backends(t)
+			hubA, hubB := guiapi.NewHub(), guiapi.NewHub()
+			if err := hubA.SetBackend(backendA); err != nil {
+				t.Fatal(err)
+			}
+			if err := hubB.SetBackend(backendB); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			resA, resB := make(chan *guiapi.Update, 16), make(chan *guiapi.Update, 16)
+			go hubA.Subscribe(ctx, resA, "reports")
+			go hubB.Subscribe(ctx, resB, "reports")
+
+			// Subscribe runs in the background, so it is published until both received it
+			update := guiapi.ReplaceContent("#reports", "<p>done</p>")
+			received := map[string]bool{}
+			deadline := time.After(5 * time.Second)
+			for len(received) < 2 {
+				hubA.Publish("reports", update)
+				select {
+				case u := <-resA:
+					checkUpdate(t, u)
+					received["a"] = true
+				case u := <-resB:
+					checkUpdate(t, u)
+					received["b"] = true
+				case <-time.After(20 * time.Millisecond):
+				case <-deadline:
+					t.Fatalf("update was only received by %v", received)
+				}
+			}
+		})
+	}
+}
+
+func checkUpdate(t *testing.T, u *guiapi.Update) {
+	t.Helper()
+	if len(u.HTML) != 1 || u.HTML[0].Selector != "#reports" || u.HTML[0].Content != "<p>done</p>" {
+		t.Errorf("unexpected update %+v", u)
+	}
+}
+
+func TestBrokerRejectsWrongSecret(t *testing.T) {
+	addr := startBroker(t)
+	backend := NewTCPBackend(addr, "wrong secret")
+	defer backend.Close()
+	err := backend.Listen(func(topic string, u *guiapi.Update) {
+		t.Errorf("unexpected update for %q", topic)
+	})
+	if !errors.Is(err, ErrAuthFailed) {
+		t.Fatalf("expected ErrAuthFailed, got %v", err)
+	}
+	if !errors.Is(NewTCPBackend(addr, "").Listen(nil), ErrNoSecret) {
+		t.Error("expected ErrNoSecret for an empty secret")
+	}
+}
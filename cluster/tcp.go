@@ -0,0 +1,184 @@
+package cluster
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mbertschler/guiapi"
+)
+
+// reconnectDelay is the time that a TCPBackend waits
+// before it tries to reconnect to the Broker.
+const reconnectDelay = time.Second
+
+// ErrNotConnected is returned from Publish if the
+// TCPBackend is currently not connected to the Broker.
+var ErrNotConnected = errors.New("cluster: not connected to broker")
+
+// TCPBackend is a guiapi.Backend that connects to a Broker over TCP.
+// If the connection is lost, it reconnects until it is closed.
+type TCPBackend struct {
+	addr   string
+	secret string
+	logger *slog.Logger
+
+	lock   sync.Mutex
+	conn   net.Conn
+	closed bool
+	done   chan struct{}
+}
+
+// NewTCPBackend returns a TCPBackend that connects to the Broker
+// at the TCP address once it is set on a Hub. The secret has to
+// be the same as the one of the Broker.
+func NewTCPBackend(addr, secret string) *TCPBackend {
+	return &TCPBackend{
+		addr:   addr,
+		secret: secret,
+		logger: slog.Default(),
+		done:   make(chan struct{}),
+	}
+}
+
+// SetLogger sets the logger for connection errors, which is
+// slog.Default() if it isn't set. It has to be called before the TCPBackend is set on a Hub.
+func (t *TCPBackend) SetLogger(logger *slog.Logger) {
+	t.logger = logger
+}
+
+// Listen connects to the Broker and calls the handler for every received
+// Update. It only returns an error if the first connection attempt fails,
+// for example with ErrAuthFailed if the Broker has a different secret.
+func (t *TCPBackend) Listen(handler func(topic string, u *guiapi.Update)) error {
+	if t.secret == "" {
+		return ErrNoSecret
+	}
+	conn, reader, err := t.dial()
+	if err != nil {
+		return err
+	}
+	t.setConn(conn)
+	go t.run(conn, reader, handler)
+	return nil
+}
+
+// dial connects to the Broker and authenticates with the secret.
+func (t *TCPBackend) dial() (net.Conn, *bufio.Reader, error) {
+	conn, err := net.Dial("tcp", t.addr)
+	if err != nil {
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(conn)
+	err = dialHandshake(conn, reader, t.secret)
+	if err != nil {
+		conn.Close()
+		return nil, nil, err
+	}
+	return conn, reader, nil
+}
+
+// Publish sends the Update for the topic to the Broker.
+func (t *TCPBackend) Publish(topic string, u *guiapi.Update) error {
+	buf, err := encodeMessage(topic, u)
+	if err != nil {
+		return err
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.conn == nil {
+		return ErrNotConnected
+	}
+	_, err = t.conn.Write(buf)
+	return err
+}
+
+// Close closes the connection to the Broker and stops reconnecting.
+func (t *TCPBackend) Close() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	close(t.done)
+	if t.conn == nil {
+		return nil
+	}
+	return t.conn.Close()
+}
+
+func (t *TCPBackend) setConn(conn net.Conn) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closed {
+		conn.Close()
+		return false
+	}
+	t.conn = conn
+	return true
+}
+
+func (t *TCPBackend) run(conn net.Conn, reader *bufio.Reader, handler func(topic string, u *guiapi.Update)) {
+	for {
+		t.read(conn, reader, handler)
+
+		t.lock.Lock()
+		t.conn = nil
+		t.lock.Unlock()
+
+		conn, reader = t.reconnect()
+		if conn == nil {
+			return
+		}
+	}
+}
+
+func (t *TCPBackend) read(conn net.Conn, reader *bufio.Reader, handler func(topic string, u *guiapi.Update)) {
+	defer conn.Close()
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(nil, MaxMessageSize)
+	for scanner.Scan() {
+		var msg message
+		err := json.Unmarshal(scanner.Bytes(), &msg)
+		if err != nil {
+			t.logger.Warn("cluster: invalid message", "error", err)
+			continue
+		}
+		handler(msg.Topic, msg.Update)
+	}
+	if err := scanner.Err(); err != nil && !t.isClosed() {
+		t.logger.Warn("cluster: read error", "error", err)
+	}
+}
+
+func (t *TCPBackend) isClosed() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.closed
+}
+
+// reconnect tries to connect to the Broker until it succeeds or
+// the TCPBackend is closed, in which case nil is returned.
+func (t *TCPBackend) reconnect() (net.Conn, *bufio.Reader) {
+	for {
+		select {
+		case <-t.done:
+			return nil, nil
+		case <-time.After(reconnectDelay):
+		}
+		conn, reader, err := t.dial()
+		if err != nil {
+			t.logger.Warn("cluster: reconnect error", "error", err)
+			continue
+		}
+		if !t.setConn(conn) {
+			return nil, nil
+		}
+		return conn, reader
+	}
+}
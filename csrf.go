@@ -0,0 +1,140 @@
+package guiapi
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"slices"
+)
+
+const (
+	// CSRFCookieName is the name of the cookie that holds the CSRF token.
+	CSRFCookieName = "guiapi_csrf"
+	// CSRFHeaderName is the name of the header that the browser
+	// uses to send the CSRF token with every action call.
+	CSRFHeaderName = "X-Guiapi-Csrf"
+
+	csrfTokenSize = 32
+)
+
+// errCSRF is returned when an action request fails the CSRF check.
+var errCSRF = errors.New("the request failed the CSRF check")
+
+// CSRFToken returns the CSRF token of the browser session, and sets the
+// cookie if the browser didn't send one yet. The server calls it for every
+// page, and the JavaScript client reads the token from the cookie. Pages can
+// also include the token in a meta tag, which is preferred by the client:
+//
+//	<meta name="guiapi-csrf" content="{{token}}">
+func (c *PageCtx) CSRFToken() string {
+	if c.csrfToken != "" {
+		return c.csrfToken
+	}
+	if token, ok := csrfCookie(c.Request); ok {
+		c.csrfToken = token
+		return token
+	}
+	buf := make([]byte, csrfTokenSize)
+	_, err := rand.Read(buf)
+	if err != nil {
+		// crypto/rand never fails on supported platforms
+		panic(err)
+	}
+	c.csrfToken = base64.RawURLEncoding.EncodeToString(buf)
+	// the cookie is not HttpOnly, because the client reads the token from it
+	// and sends it as header, other origins can neither read nor set it
+	http.SetCookie(c.Writer, &http.Cookie{
+		Name:     CSRFCookieName,
+		Value:    c.csrfToken,
+		Path:     c.server.opts.BasePath + "/",
+		Secure:   c.Request.TLS != nil,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.csrfToken
+}
+
+// setCSRFCookie makes sure that the browser has a CSRF cookie before it
+// gets a page, so that pages work without including the token themselves.
+func (s *Server) setCSRFCookie(c *PageCtx) {
+	if !s.opts.DisableCSRF {
+		c.CSRFToken()
+	}
+}
+
+// csrfCookie returns the CSRF token from the cookie of the request,
+// if it has the format of a token that was created by CSRFToken().
+func csrfCookie(r *http.Request) (string, bool) {
+	cookie, err := r.Cookie(CSRFCookieName)
+	if err != nil {
+		return "", false
+	}
+	buf, err := base64.RawURLEncoding.DecodeString(cookie.Value)
+	if err != nil || len(buf) != csrfTokenSize {
+		return "", false
+	}
+	return cookie.Value, true
+}
+
+// checkCSRF returns errCSRF if the request was not sent by a page of the
+// same origin. The Sec-Fetch-Site or Origin headers have to match, and the
+// CSRF token header has to match the cookie.
+func (s *Server) checkCSRF(r *http.Request) error {
+	if s.opts.DisableCSRF {
+		return nil
+	}
+	err := s.checkSameOrigin(r)
+	if err != nil {
+		return err
+	}
+	token, ok := csrfCookie(r)
+	if !ok {
+		return errCSRF
+	}
+	header := r.Header.Get(CSRFHeaderName)
+	if subtle.ConstantTimeCompare([]byte(header), []byte(token)) != 1 {
+		return errCSRF
+	}
+	return nil
+}
+
+// checkActionOrigin returns errCSRF if actions may not be sent over the
+// WebSocket connection of the request. The connection itself may also be
+// opened from Options.OriginPatterns, but actions are only accepted from
+// the same origin or Options.TrustedOrigins, like the action endpoint.
+func (s *Server) checkActionOrigin(r *http.Request) error {
+	if s.opts.DisableCSRF {
+		return nil
+	}
+	return s.checkSameOrigin(r)
+}
+
+// checkSameOrigin returns errCSRF if the Sec-Fetch-Site or Origin headers
+// show that the request was sent from a different origin that is not
+// listed in Options.TrustedOrigins.
+func (s *Server) checkSameOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	switch r.Header.Get("Sec-Fetch-Site") {
+	case "", "same-origin":
+	default:
+		if !slices.Contains(s.opts.TrustedOrigins, origin) {
+			return errCSRF
+		}
+	}
+	if origin != "" && !slices.Contains(s.opts.TrustedOrigins, origin) {
+		u, err := url.Parse(origin)
+		if err != nil || u.Host != r.Host {
+			return errCSRF
+		}
+	}
+	return nil
+}
+
+// rejectCSRF sends the error response for a request that failed the CSRF check.
+func (s *Server) rejectCSRF(c *PageCtx) {
+	s.logger.Warn("guiapi: CSRF check failed", "origin", c.Request.Header.Get("Origin"),
+		"secFetchSite", c.Request.Header.Get("Sec-Fetch-Site"))
+	s.writeError(c.Writer, errCSRF)
+}
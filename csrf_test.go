@@ -0,0 +1,155 @@
+package guiapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var testCSRFToken = strings.Repeat("A", 43)
+
+func TestCSRF(t *testing.T) {
+	cases := []struct {
+		name     string
+		options  []Option
+		cookie   string
+		header   string
+		origin   string
+		site     string
+		wantCode string
+	}{
+		{name: "valid", cookie: testCSRFToken, header: testCSRFToken},
+		{name: "same origin", cookie: testCSRFToken, header: testCSRFToken, origin: "http://example.com", site: "same-origin"},
+		{name: "missing cookie", header: testCSRFToken, wantCode: "csrf"},
+		{name: "malformed cookie", cookie: "short", header: "short", wantCode: "csrf"},
+		{name: "missing header", cookie: testCSRFToken, wantCode: "csrf"},
+		{name: "header mismatch", cookie: testCSRFToken, header: strings.Repeat("B", 43), wantCode: "csrf"},
+		{name: "cross-site", cookie: testCSRFToken, header: testCSRFToken, site: "cross-site", wantCode: "csrf"},
+		{name: "same-site", cookie: testCSRFToken, header: testCSRFToken, site: "same-site", wantCode: "csrf"},
+		{name: "other origin", cookie: testCSRFToken, header: testCSRFToken, origin: "http://evil.com", wantCode: "csrf"},
+		{name: "invalid origin", cookie: testCSRFToken, header: testCSRFToken, origin: "http://%zz", wantCode: "csrf"},
+		{
+			name:    "trusted origin",
+			options: []Option{WithTrustedOrigins("https://app.example.com")},
+			cookie:  testCSRFToken,
+			header:  testCSRFToken,
+			origin:  "https://app.example.com",
+			site:    "same-site",
+		},
+		{
+			name:     "untrusted origin",
+			options:  []Option{WithTrustedOrigins("https://app.example.com")},
+			cookie:   testCSRFToken,
+			header:   testCSRFToken,
+			origin:   "https://other.example.com",
+			site:     "same-site",
+			wantCode: "csrf",
+		},
+		{name: "disabled", options: []Option{WithoutCSRF()}, origin: "http://evil.com", site: "cross-site"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := New(c.options...)
+			s.AddAction("Ping", func(*ActionCtx) (*Update, error) {
+				return &Update{}, nil
+			})
+			r := httptest.NewRequest("POST", "http://example.com/guiapi", strings.NewReader(`{"Name":"Ping"}`))
+			if c.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: c.cookie})
+			}
+			if c.header != "" {
+				r.Header.Set(CSRFHeaderName, c.header)
+			}
+			if c.origin != "" {
+				r.Header.Set("Origin", c.origin)
+			}
+			if c.site != "" {
+				r.Header.Set("Sec-Fetch-Site", c.site)
+			}
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, r)
+
+			var res Update
+			err := json.Unmarshal(w.Body.Bytes(), &res)
+			if err != nil {
+				t.Fatalf("decoding response %s: %v", w.Body, err)
+			}
+			code := ""
+			if res.Error != nil {
+				code = res.Error.Code
+			}
+			if code != c.wantCode {
+				t.Errorf("got error code %q, want %q", code, c.wantCode)
+			}
+			if c.wantCode == "csrf" && w.Code != http.StatusForbidden {
+				t.Errorf("got status %d, want 403", w.Code)
+			}
+		})
+	}
+}
+
+func TestCheckActionOrigin(t *testing.T) {
+	cases := []struct {
+		name    string
+		options []Option
+		origin  string
+		site    string
+		wantErr bool
+	}{
+		{name: "no origin"},
+		{name: "same origin", origin: "http://example.com", site: "same-origin"},
+		{name: "stream origin", options: []Option{WithOriginPatterns("streams.com")}, origin: "http://streams.com", site: "cross-site", wantErr: true},
+		{name: "other origin", origin: "http://evil.com", wantErr: true},
+		{
+			name:    "trusted origin",
+			options: []Option{WithOriginPatterns("streams.com"), WithTrustedOrigins("http://streams.com")},
+			origin:  "http://streams.com",
+			site:    "cross-site",
+		},
+		{name: "disabled", options: []Option{WithoutCSRF()}, origin: "http://evil.com", site: "cross-site"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := New(c.options...)
+			// WebSocket connections don't send the CSRF header, so there is no cookie check
+			r := httptest.NewRequest("GET", "http://example.com/guiapi/ws", nil)
+			if c.origin != "" {
+				r.Header.Set("Origin", c.origin)
+			}
+			if c.site != "" {
+				r.Header.Set("Sec-Fetch-Site", c.site)
+			}
+			err := s.checkActionOrigin(r)
+			if c.wantErr && !errors.Is(err, errCSRF) {
+				t.Errorf("got error %v, want errCSRF", err)
+			}
+			if !c.wantErr && err != nil {
+				t.Errorf("got error %v, want nil", err)
+			}
+		})
+	}
+}
+
+func TestCSRFCookieOnPage(t *testing.T) {
+	s := New()
+	s.AddPage("/", func(c *PageCtx) (Page, error) {
+		return nil, nil
+	})
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("GET", "http://example.com/", nil))
+	var cookie *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		if c.Name == CSRFCookieName {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatal("page didn't set the CSRF cookie")
+	}
+	if cookie.HttpOnly {
+		t.Error("CSRF cookie is HttpOnly, the client can't read it")
+	}
+}
@@ -0,0 +1,488 @@
+package guiapi
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mbertschler/guiapi/api"
+	"golang.org/x/net/html"
+	"golang.org/x/net/html/atom"
+)
+
+// Differ remembers the HTML that was last sent to a browser for every
+// selector, and turns newly rendered HTML for the same selector into the
+// smallest set of HTML updates that changes the old into the new HTML.
+// This way a stream can render a whole table on every change, but only
+// the changed rows and cells are sent over the connection.
+//
+// Every connection needs its own Differ, because it assumes that the
+// elements in the browser only get changed by the updates that it returned.
+// Hub.SubscribeDiff() creates one for every subscription. A Differ is
+// safe for concurrent use.
+type Differ struct {
+	lock sync.Mutex
+	last map[string]*html.Node
+}
+
+// NewDiffer returns a new Differ that doesn't know any HTML yet.
+func NewDiffer() *Differ {
+	return &Differ{
+		last: map[string]*html.Node{},
+	}
+}
+
+// Diff returns the HTML updates that change the element that gets selected
+// by the passed selector from the HTML that was last passed for the same
+// selector to the HTML content. The content has to consist of a single
+// element. The first time a selector is passed, or if the content can't be
+// diffed, the whole element is sent as a Morph update.
+func (d *Differ) Diff(selector, content string) []api.HTMLUpdate {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	full := []api.HTMLUpdate{{
+		Operation: api.HTMLMorph,
+		Selector:  selector,
+		Content:   content,
+	}}
+	node, ok := parseElement(content)
+	if !ok || strings.Contains(selector, ",") {
+		delete(d.last, selector)
+		return full
+	}
+	last := d.last[selector]
+	d.last[selector] = node
+	if last == nil {
+		return full
+	}
+	var ops []api.HTMLUpdate
+	diffElement(&ops, selector, last, node)
+	if diffSize(ops) >= len(content) {
+		return full
+	}
+	return ops
+}
+
+// Update returns a copy of the Update, in which all HTMLReplaceElement
+// and HTMLMorph updates are replaced by the result of Diff(). All other HTML
+// updates, and the ones that apply to all matching elements, are kept, and
+// the Differ forgets the HTML of their selector.
+func (d *Differ) Update(u *Update) *Update {
+	if u == nil {
+		return nil
+	}
+	out := *u
+	out.HTML = nil
+	for _, h := range u.HTML {
+		if h.All || (h.Operation != api.HTMLReplaceElement && h.Operation != api.HTMLMorph) {
+			d.Forget(h.Selector)
+			out.HTML = append(out.HTML, h)
+			continue
+		}
+		ops := d.Diff(h.Selector, h.Content)
+		for i := range ops {
+			ops[i].Required = h.Required
+		}
+		out.HTML = append(out.HTML, ops...)
+	}
+	return &out
+}
+
+// Forget removes the HTML of the selector, so that the next call to Diff()
+// sends the whole element again. It should be called if the element was
+// changed by other updates than the ones returned by the Differ.
+func (d *Differ) Forget(selector string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	delete(d.last, selector)
+}
+
+// parseElement parses the HTML content and returns its only element.
+// The content is parsed in a template, so that it can also be a table row.
+func parseElement(content string) (*html.Node, bool) {
+	context := &html.Node{
+		Type:     html.ElementNode,
+		Data:     "template",
+		DataAtom: atom.Template,
+	}
+	nodes, err := html.ParseFragment(strings.NewReader(content), context)
+	if err != nil {
+		return nil, false
+	}
+	var element *html.Node
+	for _, n := range nodes {
+		switch {
+		case n.Type == html.ElementNode && element == nil:
+			element = n
+		case n.Type == html.TextNode && strings.TrimSpace(n.Data) == "":
+		default:
+			return nil, false
+		}
+	}
+	return element, element != nil
+}
+
+// diffElement adds the updates that change old into next to ops. Both
+// elements are selected by selector.
+func diffElement(ops *[]api.HTMLUpdate, selector string, old, next *html.Node) {
+	if old.Data != next.Data || old.Namespace != next.Namespace ||
+		attr(old, "id") != attr(next, "id") {
+		*ops = append(*ops, api.HTMLUpdate{
+			Operation: api.HTMLReplaceElement,
+			Selector:  selector,
+			Content:   render(next),
+		})
+		return
+	}
+	if equalNodes(old, next) {
+		return
+	}
+	if isHydrated(next) || isFormInput(next) {
+		// event listeners and input state are handled by the morph in the browser
+		*ops = append(*ops, api.HTMLUpdate{
+			Operation: api.HTMLMorph,
+			Selector:  selector,
+			Content:   render(next),
+		})
+		return
+	}
+	diffAttributes(ops, selector, old, next)
+	diffChildren(ops, selector, old, next)
+}
+
+// diffAttributes adds the updates that change the attributes of old to the ones of next.
+func diffAttributes(ops *[]api.HTMLUpdate, selector string, old, next *html.Node) {
+	for _, a := range old.Attr {
+		if !hasAttr(next, a.Key) {
+			*ops = append(*ops, api.HTMLUpdate{
+				Operation: api.HTMLRemoveAttribute,
+				Selector:  selector,
+				Name:      a.Key,
+			})
+		}
+	}
+	for _, a := range next.Attr {
+		if !hasAttr(old, a.Key) || attr(old, a.Key) != a.Val {
+			*ops = append(*ops, api.HTMLUpdate{
+				Operation: api.HTMLSetAttribute,
+				Selector:  selector,
+				Name:      a.Key,
+				Content:   a.Val,
+			})
+		}
+	}
+}
+
+// diffChildren adds the updates that change the children of old to the
+// ones of next. If all children are elements with an id, they are matched
+// by their id, otherwise by their position. If children with an id changed
+// their position, or the children also contain text that changed, the
+// parent is morphed or its content is replaced.
+func diffChildren(ops *[]api.HTMLUpdate, selector string, old, next *html.Node) {
+	oldChildren, newChildren := children(old), children(next)
+	if !onlyElements(oldChildren) || !onlyElements(newChildren) {
+		diffMixedChildren(ops, selector, old, next)
+		return
+	}
+	if keyed(oldChildren) && keyed(newChildren) && diffKeyedChildren(ops, selector, oldChildren, newChildren) {
+		return
+	}
+	if movedIDs(oldChildren, newChildren) {
+		// children with an id are selected by it, which would select the
+		// wrong element while it is replaced by another one with the same id,
+		// the morph in the browser matches them by their id instead
+		*ops = append(*ops, api.HTMLUpdate{
+			Operation: api.HTMLMorph,
+			Selector:  selector,
+			Content:   render(next),
+		})
+		return
+	}
+	common := min(len(oldChildren), len(newChildren))
+	for i := 0; i < common; i++ {
+		diffElement(ops, childSelector(selector, i, oldChildren[i]), oldChildren[i], newChildren[i])
+	}
+	if len(newChildren) > common {
+		var content strings.Builder
+		for _, n := range newChildren[common:] {
+			html.Render(&content, n)
+		}
+		*ops = append(*ops, api.HTMLUpdate{
+			Operation: api.HTMLAppend,
+			Selector:  selector,
+			Content:   content.String(),
+		})
+	}
+	// removed from the end, so that the positions of the others don't change
+	for i := len(oldChildren) - 1; i >= common; i-- {
+		*ops = append(*ops, api.HTMLUpdate{
+			Operation: api.HTMLRemove,
+			Selector:  childSelector(selector, i, oldChildren[i]),
+		})
+	}
+}
+
+// diffMixedChildren handles children that contain text. If only elements
+// changed, they are diffed by their position, otherwise the content of
+// the parent is replaced, or the parent is morphed if it has elements.
+func diffMixedChildren(ops *[]api.HTMLUpdate, selector string, old, next *html.Node) {
+	oldChildren, newChildren := children(old), children(next)
+	sameText := len(oldChildren) == len(newChildren)
+	for i := 0; sameText && i < len(oldChildren); i++ {
+		o, n := oldChildren[i], newChildren[i]
+		sameText = o.Type == n.Type && (o.Type == html.ElementNode || o.Data == n.Data)
+	}
+	if sameText {
+		index := 0
+		for i, n := range newChildren {
+			if n.Type != html.ElementNode {
+				continue
+			}
+			diffElement(ops, childSelector(selector, index, oldChildren[i]), oldChildren[i], n)
+			index++
+		}
+		return
+	}
+	if hasElements(next) || isRawText(next) {
+		*ops = append(*ops, api.HTMLUpdate{
+			Operation: api.HTMLMorph,
+			Selector:  selector,
+			Content:   render(next),
+		})
+		return
+	}
+	var content strings.Builder
+	for _, n := range newChildren {
+		html.Render(&content, n)
+	}
+	*ops = append(*ops, api.HTMLUpdate{
+		Operation: api.HTMLReplaceContent,
+		Selector:  selector,
+		Content:   content.String(),
+	})
+}
+
+// diffKeyedChildren diffs children that all have an id. It returns false if
+// the children that exist in both lists are in a different order, then they
+// are diffed by their position.
+func diffKeyedChildren(ops *[]api.HTMLUpdate, selector string, oldChildren, newChildren []*html.Node) bool {
+	oldByID := map[string]*html.Node{}
+	for _, n := range oldChildren {
+		oldByID[attr(n, "id")] = n
+	}
+	newIDs := map[string]bool{}
+	var kept []string
+	for _, n := range newChildren {
+		id := attr(n, "id")
+		newIDs[id] = true
+		if oldByID[id] != nil {
+			kept = append(kept, id)
+		}
+	}
+	i := 0
+	for _, n := range oldChildren {
+		id := attr(n, "id")
+		if !newIDs[id] {
+			continue
+		}
+		if kept[i] != id {
+			return false
+		}
+		i++
+	}
+
+	for _, n := range oldChildren {
+		id := attr(n, "id")
+		if !newIDs[id] {
+			*ops = append(*ops, api.HTMLUpdate{
+				Operation: api.HTMLRemove,
+				Selector:  idSelector(id),
+			})
+		}
+	}
+	// consecutive new children are inserted with a single update
+	inserted := -1
+	for i, n := range newChildren {
+		id := attr(n, "id")
+		if old := oldByID[id]; old != nil {
+			diffElement(ops, idSelector(id), old, n)
+			continue
+		}
+		if inserted >= 0 && inserted == len(*ops)-1 && oldByID[attr(newChildren[i-1], "id")] == nil {
+			(*ops)[inserted].Content += render(n)
+			continue
+		}
+		inserted = len(*ops)
+		if i == 0 {
+			*ops = append(*ops, api.HTMLUpdate{
+				Operation: api.HTMLPrepend,
+				Selector:  selector,
+				Content:   render(n),
+			})
+			continue
+		}
+		*ops = append(*ops, api.HTMLUpdate{
+			Operation: api.HTMLInsertAfter,
+			Selector:  idSelector(attr(newChildren[i-1], "id")),
+			Content:   render(n),
+		})
+	}
+	return true
+}
+
+// movedIDs returns true if an id of the new children belongs to an old
+// child at a different position.
+func movedIDs(oldChildren, newChildren []*html.Node) bool {
+	oldIndex := map[string]int{}
+	for i, n := range oldChildren {
+		if id := attr(n, "id"); id != "" {
+			oldIndex[id] = i
+		}
+	}
+	for i, n := range newChildren {
+		id := attr(n, "id")
+		if index, ok := oldIndex[id]; ok && id != "" && index != i {
+			return true
+		}
+	}
+	return false
+}
+
+// children returns the child nodes of n, without comments.
+func children(n *html.Node) []*html.Node {
+	var out []*html.Node
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type != html.CommentNode {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+func onlyElements(nodes []*html.Node) bool {
+	for _, n := range nodes {
+		if n.Type != html.ElementNode {
+			return false
+		}
+	}
+	return true
+}
+
+func hasElements(n *html.Node) bool {
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		if c.Type == html.ElementNode {
+			return true
+		}
+	}
+	return false
+}
+
+// keyed returns true if all nodes have a unique id.
+func keyed(nodes []*html.Node) bool {
+	ids := map[string]bool{}
+	for _, n := range nodes {
+		id := attr(n, "id")
+		if id == "" || ids[id] {
+			return false
+		}
+		ids[id] = true
+	}
+	return true
+}
+
+// isHydrated returns true for elements that get event listeners or init
+// functions in the browser, which can't be updated attribute by attribute.
+func isHydrated(n *html.Node) bool {
+	return hasAttr(n, "ga-on") || hasAttr(n, "ga-init") || hasAttr(n, "ga-link")
+}
+
+func isFormInput(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.Input, atom.Textarea, atom.Select, atom.Option:
+		return true
+	}
+	return false
+}
+
+// isRawText returns true for elements whose content is not parsed as HTML.
+func isRawText(n *html.Node) bool {
+	switch n.DataAtom {
+	case atom.Script, atom.Style, atom.Textarea, atom.Title:
+		return true
+	}
+	return false
+}
+
+func attr(n *html.Node, key string) string {
+	for _, a := range n.Attr {
+		if a.Key == key && a.Namespace == "" {
+			return a.Val
+		}
+	}
+	return ""
+}
+
+func hasAttr(n *html.Node, key string) bool {
+	for _, a := range n.Attr {
+		if a.Key == key && a.Namespace == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// equalNodes returns true if both nodes and their children are the same.
+func equalNodes(a, b *html.Node) bool {
+	if a.Type != b.Type || a.Data != b.Data || a.Namespace != b.Namespace || len(a.Attr) != len(b.Attr) {
+		return false
+	}
+	for i := range a.Attr {
+		if a.Attr[i] != b.Attr[i] {
+			return false
+		}
+	}
+	ac, bc := a.FirstChild, b.FirstChild
+	for ; ac != nil && bc != nil; ac, bc = ac.NextSibling, bc.NextSibling {
+		if !equalNodes(ac, bc) {
+			return false
+		}
+	}
+	return ac == nil && bc == nil
+}
+
+// childSelector returns the selector for the child element at the index
+// of the parent, as it currently is in the browser. Children with an id
+// are selected by their id.
+func childSelector(parent string, index int, child *html.Node) string {
+	if id := attr(child, "id"); id != "" {
+		return idSelector(id)
+	}
+	return parent + " > :nth-child(" + strconv.Itoa(index+1) + ")"
+}
+
+var cssIdentifier = regexp.MustCompile(`^-?[A-Za-z_][A-Za-z0-9_-]*$`)
+
+// idSelector returns the CSS selector for the element with the id.
+func idSelector(id string) string {
+	if cssIdentifier.MatchString(id) {
+		return "#" + id
+	}
+	return `[id="` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(id) + `"]`
+}
+
+func render(n *html.Node) string {
+	var buf strings.Builder
+	html.Render(&buf, n)
+	return buf.String()
+}
+
+// diffSize returns the approximate number of bytes that the updates need.
+func diffSize(ops []api.HTMLUpdate) int {
+	size := 0
+	for _, op := range ops {
+		size += len(op.Selector) + len(op.Name) + len(op.Content) + 16
+	}
+	return size
+}
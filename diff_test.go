@@ -0,0 +1,236 @@
+package guiapi
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mbertschler/guiapi/api"
+)
+
+// keyedList renders a list with a row for every id. The rows are long
+// enough that diffs of a few rows are smaller than the whole list.
+func keyedList(ids ...int) string {
+	var b strings.Builder
+	b.WriteString(`<ul id="list">`)
+	for _, id := range ids {
+		fmt.Fprintf(&b, `<li id="r%d">row number %d of the list</li>`, id, id)
+	}
+	b.WriteString(`</ul>`)
+	return b.String()
+}
+
+// table renders a table without ids, the cells contain the passed values.
+func table(values ...string) string {
+	var b strings.Builder
+	b.WriteString(`<table id="t"><tbody>`)
+	for i, v := range values {
+		fmt.Fprintf(&b, `<tr><td>row %d of the table</td><td>%s</td></tr>`, i+1, v)
+	}
+	b.WriteString(`</tbody></table>`)
+	return b.String()
+}
+
+func rows(n int) []int {
+	ids := make([]int, n)
+	for i := range ids {
+		ids[i] = i + 1
+	}
+	return ids
+}
+
+func values(n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = "value"
+	}
+	return out
+}
+
+func TestDiff(t *testing.T) {
+	changed := values(20)
+	changed[3] = "changed"
+
+	cases := []struct {
+		name     string
+		selector string
+		old      string
+		next     string
+		want     []api.HTMLUpdate
+	}{
+		{
+			name:     "unchanged",
+			selector: "#list",
+			old:      keyedList(rows(20)...),
+			next:     keyedList(rows(20)...),
+			want:     nil,
+		},
+		{
+			name:     "keyed insert",
+			selector: "#list",
+			old:      keyedList(rows(20)...),
+			next:     keyedList(append(rows(19), 100, 101, 20)...),
+			want: []api.HTMLUpdate{{
+				Operation: api.HTMLInsertAfter,
+				Selector:  "#r19",
+				Content:   `<li id="r100">row number 100 of the list</li><li id="r101">row number 101 of the list</li>`,
+			}},
+		},
+		{
+			name:     "keyed prepend",
+			selector: "#list",
+			old:      keyedList(rows(20)...),
+			next:     keyedList(append([]int{0}, rows(20)...)...),
+			want: []api.HTMLUpdate{{
+				Operation: api.HTMLPrepend,
+				Selector:  "#list",
+				Content:   `<li id="r0">row number 0 of the list</li>`,
+			}},
+		},
+		{
+			name:     "keyed remove",
+			selector: "#list",
+			old:      keyedList(rows(20)...),
+			next:     keyedList(append(rows(4), rows(20)[6:]...)...),
+			want: []api.HTMLUpdate{
+				{Operation: api.HTMLRemove, Selector: "#r5"},
+				{Operation: api.HTMLRemove, Selector: "#r6"},
+			},
+		},
+		{
+			name:     "keyed reorder",
+			selector: "#list",
+			old:      keyedList(rows(20)...),
+			next:     keyedList(append([]int{2, 1}, rows(20)[2:]...)...),
+			want: []api.HTMLUpdate{{
+				Operation: api.HTMLMorph,
+				Selector:  "#list",
+				Content:   keyedList(append([]int{2, 1}, rows(20)[2:]...)...),
+			}},
+		},
+		{
+			name:     "positional change",
+			selector: "#t",
+			old:      table(values(20)...),
+			next:     table(changed...),
+			want: []api.HTMLUpdate{{
+				Operation: api.HTMLReplaceContent,
+				Selector:  "#t > :nth-child(1) > :nth-child(4) > :nth-child(2)",
+				Content:   "changed",
+			}},
+		},
+		{
+			name:     "positional append",
+			selector: "#t",
+			old:      table(values(20)...),
+			next:     table(values(21)...),
+			want: []api.HTMLUpdate{{
+				Operation: api.HTMLAppend,
+				Selector:  "#t > :nth-child(1)",
+				Content:   `<tr><td>row 21 of the table</td><td>value</td></tr>`,
+			}},
+		},
+		{
+			name:     "positional remove",
+			selector: "#t",
+			old:      table(values(20)...),
+			next:     table(values(18)...),
+			want: []api.HTMLUpdate{
+				{Operation: api.HTMLRemove, Selector: "#t > :nth-child(1) > :nth-child(20)"},
+				{Operation: api.HTMLRemove, Selector: "#t > :nth-child(1) > :nth-child(19)"},
+			},
+		},
+		{
+			name:     "attribute change",
+			selector: "#d",
+			old:      `<div id="d" class="a">` + strings.Repeat("some long text ", 10) + `</div>`,
+			next:     `<div id="d" class="b" title="x">` + strings.Repeat("some long text ", 10) + `</div>`,
+			want: []api.HTMLUpdate{
+				{Operation: api.HTMLSetAttribute, Selector: "#d", Name: "class", Content: "b"},
+				{Operation: api.HTMLSetAttribute, Selector: "#d", Name: "title", Content: "x"},
+			},
+		},
+		{
+			name:     "mixed text change",
+			selector: "#p",
+			old:      `<p id="p">` + strings.Repeat("some long text ", 10) + `<b>bold</b> old end</p>`,
+			next:     `<p id="p">` + strings.Repeat("some long text ", 10) + `<b>bold</b> new end</p>`,
+			want: []api.HTMLUpdate{{
+				Operation: api.HTMLMorph,
+				Selector:  "#p",
+				Content:   `<p id="p">` + strings.Repeat("some long text ", 10) + `<b>bold</b> new end</p>`,
+			}},
+		},
+		{
+			name:     "mixed element change",
+			selector: "#p",
+			old:      `<p id="p">` + strings.Repeat("some long text ", 10) + `<b>bold</b> end</p>`,
+			next:     `<p id="p">` + strings.Repeat("some long text ", 10) + `<b>new</b> end</p>`,
+			want: []api.HTMLUpdate{{
+				Operation: api.HTMLReplaceContent,
+				Selector:  "#p > :nth-child(1)",
+				Content:   "new",
+			}},
+		},
+		{
+			name:     "text only",
+			selector: "#p",
+			old:      `<p id="p" class="description">` + strings.Repeat("old text ", 10) + `</p>`,
+			next:     `<p id="p" class="description">` + strings.Repeat("new text ", 9) + `</p>`,
+			want: []api.HTMLUpdate{{
+				Operation: api.HTMLReplaceContent,
+				Selector:  "#p",
+				Content:   strings.Repeat("new text ", 9),
+			}},
+		},
+		{
+			name:     "larger than the element",
+			selector: "#p",
+			old:      `<p id="p">old</p>`,
+			next:     `<p id="p">new</p>`,
+			want: []api.HTMLUpdate{{
+				Operation: api.HTMLMorph,
+				Selector:  "#p",
+				Content:   `<p id="p">new</p>`,
+			}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			d := NewDiffer()
+			first := d.Diff(c.selector, c.old)
+			if len(first) != 1 || first[0].Operation != api.HTMLMorph {
+				t.Fatalf("first diff = %+v, want a single morph", first)
+			}
+			got := d.Diff(c.selector, c.next)
+			if !reflect.DeepEqual(got, c.want) {
+				t.Errorf("diff =\n%+v\nwant\n%+v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestDifferUpdate(t *testing.T) {
+	d := NewDiffer()
+	d.Update(&Update{HTML: []api.HTMLUpdate{{Operation: api.HTMLMorph, Selector: "#list", Content: keyedList(rows(20)...)}}})
+
+	next := keyedList(rows(19)...)
+	got := d.Update(&Update{HTML: []api.HTMLUpdate{
+		{Operation: api.HTMLReplaceElement, Selector: "#list", Content: next, Required: true},
+		{Operation: api.HTMLAddClass, Selector: ".row", Name: "active", All: true},
+	}})
+	want := []api.HTMLUpdate{
+		{Operation: api.HTMLRemove, Selector: "#r20", Required: true},
+		{Operation: api.HTMLAddClass, Selector: ".row", Name: "active", All: true},
+	}
+	if !reflect.DeepEqual(got.HTML, want) {
+		t.Errorf("update =\n%+v\nwant\n%+v", got.HTML, want)
+	}
+
+	d.Forget("#list")
+	got = d.Update(&Update{HTML: []api.HTMLUpdate{{Operation: api.HTMLMorph, Selector: "#list", Content: next}}})
+	if len(got.HTML) != 1 || got.HTML[0].Operation != api.HTMLMorph {
+		t.Errorf("update after Forget = %+v, want a single morph", got.HTML)
+	}
+}
@@ -0,0 +1,170 @@
+package guiapi
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mbertschler/guiapi/api"
+)
+
+// Error is an error that controls the api.Error that is sent to the browser.
+// ActionFuncs can return it directly or wrapped, for example to point at the
+// invalid fields of a form:
+//
+//	return nil, guiapi.FieldError("email", "this email address is already taken")
+//
+// Errors that are not an *Error are sent with the code "error" and their message.
+type Error struct {
+	Code    string            // machine readable error code like "validation"
+	Message string            // human readable error message
+	Fields  map[string]string // error messages for invalid form fields
+	Details any               // additional information, encoded as JSON
+	// Retryable is set if the same request might succeed if it is retried.
+	Retryable bool
+	// Cause is the underlying error, it is not sent to the browser.
+	Cause error
+}
+
+// NewError returns a new Error with the passed code and message.
+func NewError(code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Errorf returns a new Error with the passed code and a message
+// that is formatted according to the format specifier.
+func Errorf(code, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// ValidationError returns a new Error with the code "validation"
+// and the error messages for the passed form fields.
+func ValidationError(fields map[string]string) *Error {
+	return &Error{
+		Code:    "validation",
+		Message: "the input is invalid",
+		Fields:  fields,
+	}
+}
+
+// FieldError returns a new Error with the code "validation"
+// and the error message for a single form field.
+func FieldError(field, message string) *Error {
+	return ValidationError(map[string]string{field: message})
+}
+
+// Error returns the message of the Error, including its cause.
+func (e *Error) Error() string {
+	if e.Cause != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.Cause)
+	}
+	return fmt.Sprintf("%s: %s", e.Code, e.Message)
+}
+
+// Unwrap returns the cause of the Error.
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// WithField adds the error message for a form field and returns the Error.
+func (e *Error) WithField(field, message string) *Error {
+	if e.Fields == nil {
+		e.Fields = map[string]string{}
+	}
+	e.Fields[field] = message
+	return e
+}
+
+// WithDetails sets the additional information and returns the Error.
+func (e *Error) WithDetails(details any) *Error {
+	e.Details = details
+	return e
+}
+
+// WithRetryable marks the Error as retryable and returns it.
+func (e *Error) WithRetryable() *Error {
+	e.Retryable = true
+	return e
+}
+
+// WithCause sets the underlying error and returns the Error.
+func (e *Error) WithCause(err error) *Error {
+	e.Cause = err
+	return e
+}
+
+// apiError returns the api.Error that is sent to the browser.
+func (e *Error) apiError() *api.Error {
+	return &api.Error{
+		Code:      e.Code,
+		Message:   e.Message,
+		Fields:    e.Fields,
+		Details:   e.Details,
+		Retryable: e.Retryable,
+	}
+}
+
+// errInternal is returned for internal errors that were already
+// reported, and is sent to the browser as internalError().
+var errInternal = errors.New("internal error")
+
+// internalError is sent to the browser for errors that
+// should not reveal any details, like recovered panics.
+func internalError() *api.Error {
+	return &api.Error{
+		Code:    "internal",
+		Message: "internal server error",
+	}
+}
+
+// knownErrors are the errors of the framework itself and the Errors they
+// are sent as. If message is empty, the message of the error is used.
+// The first matching entry is used, so more specific errors come first.
+var knownErrors = []struct {
+	err       error
+	code      string
+	message   string
+	retryable bool
+}{
+	{err: context.DeadlineExceeded, code: "timeout", message: "the action timed out", retryable: true},
+	{err: errPageNotFound, code: "notFound"},
+	{err: errNotUpdateable, code: "notUpdateable"},
+	{err: errInvalidRequest, code: "invalidRequest"},
+	{err: errTooLarge, code: "requestTooLarge"},
+	{err: errRateLimited, code: "rateLimited", retryable: true},
+	{err: errCSRF, code: "csrf"},
+	{err: errForbidden, code: "forbidden"},
+	{err: ErrStateNotFound, code: "stateExpired", message: "the state expired, please reload the page"},
+	{err: ErrInvalidState, code: "tamperedState", message: "the state was tampered with"},
+}
+
+// errorFromErr converts an error returned from an ActionFunc
+// into an api.Error that can be sent to the browser.
+func errorFromErr(err error) *api.Error {
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) || errors.Is(err, errInternal) {
+		return internalError()
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.apiError()
+	}
+	for _, known := range knownErrors {
+		if !errors.Is(err, known.err) {
+			continue
+		}
+		message := known.message
+		if message == "" {
+			message = err.Error()
+		}
+		return &api.Error{
+			Code:      known.code,
+			Message:   message,
+			Retryable: known.retryable,
+		}
+	}
+	return &api.Error{
+		Code:    "error",
+		Message: err.Error(),
+	}
+}
@@ -21,7 +21,8 @@ func (c *Counter) Register(s *guiapi.Server) {
 }
 
 type CounterPage struct {
-	Content html.Block
+	Content   html.Block
+	CSRFToken string
 }
 
 func (c *CounterPage) WriteHTML(w io.Writer) error {
@@ -30,6 +31,7 @@ func (c *CounterPage) WriteHTML(w io.Writer) error {
 		html.Html(nil,
 			html.Head(nil,
 				html.Meta(attr.Charset("utf-8")),
+				html.Meta(attr.Name("guiapi-csrf").Content(c.CSRFToken)),
 				html.Title(nil, html.Text("Guiapi Counter Example")),
 				html.Link(attr.Rel("stylesheet").Href("https://cdn.jsdelivr.net/npm/simpledotcss@2.2.0/simple.min.css")),
 				html.Link(attr.Rel("stylesheet").Href("/dist/bundle.css")),
@@ -61,7 +63,7 @@ func (c *Counter) RenderPage(ctx *guiapi.PageCtx) (guiapi.Page, error) {
 		html.P(nil, html.Text("guiapi is a framework for building web applications in Go.")),
 		block,
 	}
-	return &CounterPage{Content: main}, nil
+	return &CounterPage{Content: main, CSRFToken: ctx.CSRFToken()}, nil
 }
 
 func (c *Counter) RenderBlock(ctx *guiapi.PageCtx) (html.Block, error) {
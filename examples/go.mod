@@ -1,6 +1,6 @@
 module github.com/mbertschler/guiapi/examples
 
-go 1.20
+go 1.21
 
 replace github.com/mbertschler/guiapi => ../
 
@@ -13,6 +13,7 @@ require (
 	github.com/evanw/esbuild v0.17.19 // indirect
 	github.com/julienschmidt/httprouter v1.3.0 // indirect
 	github.com/klauspost/compress v1.10.3 // indirect
-	golang.org/x/sys v0.8.0 // indirect
+	golang.org/x/net v0.17.0 // indirect
+	golang.org/x/sys v0.13.0 // indirect
 	nhooyr.io/websocket v1.8.7 // indirect
 )
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/rand"
 	"embed"
 	"flag"
 	"io/fs"
@@ -41,22 +42,36 @@ func assetsFS() (fs.FS, error) {
 	return os.DirFS(dir), nil
 }
 
-func setupServer(assetsFS fs.FS) *guiapi.Server {
+func setupServer(assetsFS fs.FS) (*guiapi.Server, error) {
+	// the state key is only valid until the server restarts,
+	// which is fine because all data is kept in memory anyway
+	stateKey := make([]byte, 32)
+	_, err := rand.Read(stateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	codec, err := guiapi.NewSignedStateCodec(stateKey)
+	if err != nil {
+		return nil, err
+	}
+
 	db := NewDB()
+	server := guiapi.New(
+		guiapi.WithStateCodec(codec),
+	)
 
-	reports := NewReportsComponent(db)
+	reports := NewReportsComponent(db, server.Hub())
 	counter := &Counter{DB: db}
 	todo := &TodoList{DB: db}
 
-	server := guiapi.New()
-
 	server.AddFiles("/dist/", http.FS(assetsFS))
 
 	reports.Register(server)
 	counter.Register(server)
 	todo.Register(server)
 
-	return server
+	return server, nil
 }
 
 func main() {
@@ -76,7 +91,10 @@ func main() {
 		return
 	}
 
-	server := setupServer(fs)
+	server, err := setupServer(fs)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	log.Println("listening on localhost:8000")
 	err = http.ListenAndServe("localhost:8000", server)
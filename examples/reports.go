@@ -39,58 +39,17 @@ func (c ChangeType) String() string {
 type ReportsChange func(change ChangeType, report *Report)
 
 type ReportsDB struct {
-	transaction   sync.Mutex
-	lock          sync.Mutex
-	globalUpdates map[int64]ReportsChange
-	idUpdates     map[string]map[int64]ReportsChange
-	reports       map[string]*Report
-}
-
-func (r *ReportsDB) AddGlobalChangeListener(fn ReportsChange) int64 {
-	r.lock.Lock()
-	defer r.lock.Unlock()
-	if r.globalUpdates == nil {
-		r.globalUpdates = make(map[int64]ReportsChange)
-	}
-	listenerID := time.Now().UnixNano()
-	r.globalUpdates[listenerID] = fn
-	return listenerID
-}
-
-func (r *ReportsDB) RemoveGlobalChangeListener(id int64) {
-	r.lock.Lock()
-	defer r.lock.Unlock()
-	delete(r.globalUpdates, id)
-}
-
-func (r *ReportsDB) AddIDChangeListener(id string, fn ReportsChange) int64 {
-	r.lock.Lock()
-	defer r.lock.Unlock()
-	if r.idUpdates == nil {
-		r.idUpdates = make(map[string]map[int64]ReportsChange)
-	}
-	if r.idUpdates[id] == nil {
-		r.idUpdates[id] = make(map[int64]ReportsChange)
-	}
-	listenerID := time.Now().UnixNano()
-	r.idUpdates[id][listenerID] = fn
-	return listenerID
-}
-
-func (r *ReportsDB) RemoveIDChangeListener(id string, listenerID int64) {
-	r.lock.Lock()
-	defer r.lock.Unlock()
-	delete(r.idUpdates[id], listenerID)
+	transaction sync.Mutex
+	lock        sync.Mutex
+	onChange    ReportsChange
+	reports     map[string]*Report
 }
 
 func (r *ReportsDB) notify(change ChangeType, report *Report) {
 	r.lock.Unlock()
 	defer r.lock.Lock()
-	for _, fn := range r.globalUpdates {
-		fn(change, report)
-	}
-	for _, fn := range r.idUpdates[report.ID] {
-		fn(change, report)
+	if r.onChange != nil {
+		r.onChange(change, report)
 	}
 }
 
@@ -153,13 +112,16 @@ func (r *ReportsDB) Delete(id string) error {
 	return nil
 }
 
-func NewReportsComponent(db *DB) *Reports {
-	return &Reports{
+func NewReportsComponent(db *DB, hub *guiapi.Hub) *Reports {
+	r := &Reports{
 		SessDB: db,
+		Hub:    hub,
 		DB: &ReportsDB{
 			reports: make(map[string]*Report),
 		},
 	}
+	r.DB.onChange = r.publishChange
+	return r
 }
 
 type Report struct {
@@ -177,6 +139,7 @@ const (
 type Reports struct {
 	SessDB *DB
 	DB     *ReportsDB
+	Hub    *guiapi.Hub
 }
 
 func (r *Reports) Register(s *guiapi.Server) {
@@ -192,8 +155,10 @@ func (r *Reports) Register(s *guiapi.Server) {
 }
 
 type ReportsPage struct {
-	Content html.Block
-	Stream  ReportsStream
+	Title     string
+	Content   html.Block
+	Stream    ReportsStream
+	CSRFToken string
 }
 
 type ReportsStream struct {
@@ -214,7 +179,8 @@ func (r *ReportsPage) WriteHTML(w io.Writer) error {
 		html.Html(nil,
 			html.Head(nil,
 				html.Meta(attr.Charset("utf-8")),
-				html.Title(nil, html.Text("Blocks")),
+				html.Meta(attr.Name("guiapi-csrf").Content(r.CSRFToken)),
+				html.Title(nil, html.Text(r.Title)),
 				html.Link(attr.Rel("stylesheet").Href("https://cdn.jsdelivr.net/npm/simpledotcss@2.2.0/simple.min.css")),
 				html.Link(attr.Rel("stylesheet").Href("/dist/bundle.css")),
 			),
@@ -236,6 +202,7 @@ func (r *ReportsPage) Update() (*guiapi.Update, error) {
 	out, err := html.RenderMinifiedString(r.Content)
 	res := guiapi.ReplaceElement("#reports", out)
 	res.AddStream("Reports", r.Stream)
+	res.SetTitle(r.Title)
 	return res, err
 }
 
@@ -245,8 +212,10 @@ func (r *Reports) IndexPage(ctx *guiapi.PageCtx) (guiapi.Page, error) {
 		return nil, err
 	}
 	return &ReportsPage{
-		Content: main,
-		Stream:  ReportsStream{Overview: true},
+		Title:     "Reports",
+		Content:   main,
+		Stream:    ReportsStream{Overview: true},
+		CSRFToken: ctx.CSRFToken(),
 	}, nil
 }
 
@@ -274,7 +243,7 @@ func (r *Reports) allReportsBlock() html.Block {
 	var items html.Blocks
 	for _, report := range reports {
 		text := fmt.Sprintf(": %s %s", report.Status, report.Started.Format(time.DateTime))
-		items.Add(html.Li(nil,
+		items.Add(html.Li(attr.Id("report-"+report.ID),
 			html.A(attr.Href("/report/"+report.ID).Class("ga").Attr("ga-link", nil), html.Text(report.ID)),
 			html.Text(text),
 		))
@@ -289,7 +258,12 @@ func (r *Reports) allReportsBlock() html.Block {
 
 func (r *Reports) ReportPage(ctx *guiapi.PageCtx) (guiapi.Page, error) {
 	id := ctx.Params.ByName("id")
-	return r.renderReportPage(id)
+	page, err := r.renderReportPage(id)
+	if err != nil {
+		return nil, err
+	}
+	page.CSRFToken = ctx.CSRFToken()
+	return page, nil
 }
 
 func (r *Reports) renderReportPage(id string) (*ReportsPage, error) {
@@ -298,6 +272,7 @@ func (r *Reports) renderReportPage(id string) (*ReportsPage, error) {
 		r.singleReportBlock(id),
 	)
 	return &ReportsPage{
+		Title:   "Report " + id,
 		Content: main,
 		Stream:  ReportsStream{ID: id},
 	}, nil
@@ -322,13 +297,26 @@ type ReportsArgs struct {
 	ID string `json:"id"`
 }
 
-func (r *Reports) Start(ctx *Action, args *ReportsArgs) (*guiapi.Update, error) {
+type StartReportForm struct {
+	ID string `form:"id" validate:"required,min=3,max=40"`
+}
+
+func (r *Reports) Start(ctx *Action, _ *json.RawMessage) (*guiapi.Update, error) {
+	var form StartReportForm
+	err := guiapi.BindForm(ctx.Args, &form)
+	if err != nil {
+		return nil, err
+	}
+	if r.DB.Get(form.ID) != nil {
+		return nil, guiapi.FieldError("id", "a report with this name already exists")
+	}
+	args := &ReportsArgs{ID: form.ID}
 	report := &Report{
 		ID:      args.ID,
 		Started: time.Now(),
 		Status:  ReportStatusStarted,
 	}
-	err := r.DB.Create(report)
+	err = r.DB.Create(report)
 	if err != nil {
 		return nil, err
 	}
@@ -366,13 +354,13 @@ func (r *Reports) Cancel(ctx *Action, args *ReportsArgs) (*guiapi.Update, error)
 		return nil, err
 	}
 	out, err := html.RenderMinifiedString(r.allReportsBlock())
-	return guiapi.ReplaceElement("#all-reports", out), err
+	return guiapi.Morph("#all-reports", out), err
 }
 
 func (r *Reports) Refresh(ctx *Action, args *NoArgs) (*guiapi.Update, error) {
 	time.Sleep(2 * time.Second)
 	out, err := html.RenderMinifiedString(r.allReportsBlock())
-	return guiapi.ReplaceElement("#all-reports", out), err
+	return guiapi.Morph("#all-reports", out), err
 }
 
 func (r *Reports) SomeError(ctx *Action, args *NoArgs) (*guiapi.Update, error) {
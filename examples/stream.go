@@ -24,32 +24,36 @@ func (r *Reports) Stream(ctx context.Context, msg json.RawMessage, res chan<- *g
 	return nil
 }
 
+const reportsOverviewTopic = "reports"
+
+func reportTopic(id string) string {
+	return "report/" + id
+}
+
 func (r *Reports) overviewStream(ctx context.Context, results chan<- *guiapi.Update) error {
-	listener := r.DB.AddGlobalChangeListener(func(change ChangeType, report *Report) {
-		out, err := html.RenderMinifiedString(r.allReportsBlock())
-		res := guiapi.ReplaceElement("#all-reports", out)
-		if err != nil {
-			res.Error = &api.Error{Message: err.Error()}
-		}
-		results <- res
-	})
-
-	<-ctx.Done()
-	r.DB.RemoveGlobalChangeListener(listener)
-	return nil
+	// the overview renders all reports on every change,
+	// but only the changed list items are sent
+	return r.Hub.SubscribeDiff(ctx, results, reportsOverviewTopic)
 }
 
 func (r *Reports) detailStream(ctx context.Context, id string, results chan<- *guiapi.Update) error {
-	listener := r.DB.AddIDChangeListener(id, func(change ChangeType, report *Report) {
-		out, err := html.RenderMinifiedString(r.singleReportBlock(id))
-		res := guiapi.ReplaceElement("#single-report", out)
-		if err != nil {
-			res.Error = &api.Error{Message: err.Error()}
-		}
-		results <- res
-	})
-
-	<-ctx.Done()
-	r.DB.RemoveIDChangeListener(id, listener)
-	return nil
+	return r.Hub.Subscribe(ctx, results, reportTopic(id))
+}
+
+// publishChange renders the changed report blocks once and
+// publishes them to all streams that show these reports.
+func (r *Reports) publishChange(change ChangeType, report *Report) {
+	out, err := html.RenderMinifiedString(r.allReportsBlock())
+	res := guiapi.ReplaceElement("#all-reports", out)
+	if err != nil {
+		res.Error = &api.Error{Message: err.Error()}
+	}
+	r.Hub.Publish(reportsOverviewTopic, res)
+
+	out, err = html.RenderMinifiedString(r.singleReportBlock(report.ID))
+	res = guiapi.ReplaceElement("#single-report", out)
+	if err != nil {
+		res.Error = &api.Error{Message: err.Error()}
+	}
+	r.Hub.Publish(reportTopic(report.ID), res)
 }
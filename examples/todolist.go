@@ -19,32 +19,20 @@ type Page struct {
 type Action struct {
 	*guiapi.ActionCtx
 	Sess  *Session
-	State TodoListState
+	State *TodoListState
 }
 
 type ActionFunc[T any] func(c *Action, args *T) (*guiapi.Update, error)
 
 func ContextAction[T any](db *DB, fn ActionFunc[T]) guiapi.ActionFunc {
-	return func(c *guiapi.ActionCtx) (*guiapi.Update, error) {
-		var input T
-		if c.Args != nil {
-			err := json.Unmarshal(c.Args, &input)
-			if err != nil {
-				return nil, err
-			}
-		}
-
+	return guiapi.TypedAction(func(c *guiapi.ActionCtx, args *T, state *TodoListState) (*guiapi.Update, error) {
 		ctx := &Action{
 			ActionCtx: c,
 			Sess:      db.Session(c.Writer, c.Request),
+			State:     state,
 		}
-
-		err := json.Unmarshal(c.State, &ctx.State)
-		if err != nil {
-			return nil, err
-		}
-		return fn(ctx, &input)
-	}
+		return fn(ctx, args)
+	})
 }
 
 type PageFunc func(c *Page) (guiapi.Page, error)
@@ -61,19 +49,19 @@ func PageWrapper(db *DB) func(PageFunc) guiapi.PageFunc {
 type TodoPage struct {
 	Content html.Block
 	State   TodoListState
+	// StateJSON is the State encoded by the server for the initial page load
+	StateJSON json.RawMessage
+	CSRFToken string
 }
 
 func (t *TodoPage) WriteHTML(w io.Writer) error {
-	stateJSON, err := json.Marshal(t.State)
-	if err != nil {
-		return err
-	}
 	block := html.Blocks{
 		html.Doctype("html"),
 		html.Html(attr.Lang("en"),
 			html.Head(nil,
 				html.Meta(attr.Charset("utf-8")),
 				html.Meta(attr.Name("viewport").Content("width=device-width, initial-scale=1")),
+				html.Meta(attr.Name("guiapi-csrf").Content(t.CSRFToken)),
 				html.Title(nil, html.Text("Guiapi • TodoMVC")),
 				html.Link(attr.Rel("stylesheet").Href("https://cdn.jsdelivr.net/npm/todomvc-app-css@2.4.2/index.min.css")),
 				html.Link(attr.Rel("stylesheet").Href("/dist/bundle.css")),
@@ -89,7 +77,7 @@ func (t *TodoPage) WriteHTML(w io.Writer) error {
 					html.P(attr.Class("biglink"), html.A(attr.Href("/counter"), html.Text("Counter Example"))),
 					html.P(attr.Class("biglink"), html.A(attr.Href("/reports"), html.Text("Reports Example"))),
 				),
-				html.Script(nil, html.JS("var state = "+string(stateJSON)+";")),
+				html.Script(nil, html.JS("var state = "+string(t.StateJSON)+";")),
 				html.Script(attr.Src("/dist/bundle.js")),
 			),
 		),
@@ -148,11 +136,19 @@ func (t *TodoList) RenderFullPage(page string) guiapi.PageFunc {
 		if err != nil {
 			return nil, err
 		}
+		state := TodoListState{
+			Page: page,
+		}
+		stateJSON, err := ctx.EncodeState(state)
+		if err != nil {
+			return nil, err
+		}
 		return &TodoPage{
-			Content: content,
-			State: TodoListState{
-				Page: page,
-			}}, nil
+			Content:   content,
+			State:     state,
+			StateJSON: stateJSON,
+			CSRFToken: ctx.CSRFToken(),
+		}, nil
 	})
 }
 
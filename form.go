@@ -0,0 +1,385 @@
+package guiapi
+
+import (
+	"encoding"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/mail"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// FormActionFunc is an action handler function that gets the form values,
+// which were gathered in the browser with the ga-values attribute, bound to
+// a struct. See BindForm() for how the values are decoded and validated.
+type FormActionFunc[Form, State any] func(c *ActionCtx, form *Form, state *State) (*Update, error)
+
+// FormAction wraps a FormActionFunc into a regular ActionFunc, so that it can
+// be registered with AddAction(). The Args are bound to the Form struct with
+// BindForm(), and the function is only called if all values are valid.
+// Otherwise the browser gets a validation error, which shows the messages
+// next to the invalid inputs. The State is handled like in TypedAction().
+func FormAction[Form, State any](fn FormActionFunc[Form, State]) ActionFunc {
+	return TypedAction(func(c *ActionCtx, _ *json.RawMessage, state *State) (*Update, error) {
+		var form Form
+		err := BindForm(c.Args, &form)
+		if err != nil {
+			return nil, err
+		}
+		return fn(c, &form, state)
+	})
+}
+
+// errFormDefinition is wrapped by the errors of BindForm that are caused by
+// mistakes in the struct definition. They are reported, and the browser only
+// gets an internal error, so that the details of the struct are not revealed.
+var errFormDefinition = errors.New("guiapi: invalid form struct")
+
+// AddFormAction registers a FormActionFunc with the passed name on the server.
+// It is a shorthand for s.AddAction(name, FormAction(fn), mw...).
+func AddFormAction[Form, State any](s *Server, name string, fn FormActionFunc[Form, State], mw ...Middleware) {
+	s.AddAction(name, FormAction(fn), mw...)
+}
+
+// BindForm decodes the form values in args into the struct that dst points to,
+// and validates them. The args are a JSON object that maps the input names to
+// a string or a list of strings, like the ones gathered with ga-values.
+//
+// The struct fields are matched by their form tag, or by their name if they
+// don't have one. Fields with the tag form:"-" are skipped, and the fields
+// of embedded structs are bound like the fields of the outer struct. The values are
+// converted to the type of the field, which can be a string, bool, int, uint,
+// float, time.Time, a type that implements encoding.TextUnmarshaler, or a
+// slice or pointer of these. A []byte is set like a string. Checkboxes set bools to true if they are
+// checked, and multiple checkboxes or multi-selects with the same name fill
+// slices. Dates use the formats of date and datetime-local inputs, or RFC 3339.
+// Other layouts can be set with the layout tag.
+//
+// The validate tag contains a comma separated list of rules:
+//
+//	required   the value must not be empty, checkboxes must be checked
+//	min=N      numbers must be at least N, strings have at least N characters
+//	           and slices at least N items
+//	max=N      numbers must be at most N, strings have at most N characters
+//	           and slices at most N items
+//	email      the value must be an email address
+//	oneof=a|b  the value must be one of the listed values
+//
+// If values can't be converted or are invalid, a ValidationError with the
+// messages for all invalid fields is returned. Mistakes in the struct, like
+// unsupported field types or malformed rules, return an internal error.
+func BindForm(args json.RawMessage, dst any) error {
+	ptr := reflect.ValueOf(dst)
+	if ptr.Kind() != reflect.Pointer || ptr.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("%w: BindForm needs a pointer to a struct, got %T", errFormDefinition, dst)
+	}
+	values, err := formValues(args)
+	if err != nil {
+		return Errorf("invalidArgs", "invalid form values: %v", err).WithCause(err)
+	}
+	fields := map[string]string{}
+	err = bindStruct(ptr.Elem(), values, fields)
+	if err != nil {
+		return err
+	}
+	if len(fields) > 0 {
+		return ValidationError(fields)
+	}
+	return nil
+}
+
+// formValues decodes the JSON object of form values. Every value can be
+// a string, a list of strings or any other JSON value, which is used as text.
+func formValues(args json.RawMessage) (map[string][]string, error) {
+	values := map[string][]string{}
+	if len(args) == 0 || string(args) == "null" {
+		return values, nil
+	}
+	var raw map[string]json.RawMessage
+	err := json.Unmarshal(args, &raw)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range raw {
+		var list []json.RawMessage
+		if json.Unmarshal(value, &list) != nil {
+			list = []json.RawMessage{value}
+		}
+		for _, item := range list {
+			var s string
+			if json.Unmarshal(item, &s) != nil {
+				if string(item) == "null" {
+					continue
+				}
+				s = string(item)
+			}
+			values[name] = append(values[name], s)
+		}
+	}
+	return values, nil
+}
+
+func bindStruct(v reflect.Value, values map[string][]string, fields map[string]string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("form")
+		if name == "-" {
+			continue
+		}
+		// the exported fields of embedded structs are bound
+		// like encoding/json does, also if the type is unexported
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			err := bindStruct(v.Field(i), values, fields)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		err := bindField(v.Field(i), field, name, values[name], fields)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bindField sets and validates a single field. Invalid values are added to
+// fields, errors are only returned for mistakes in the struct definition.
+func bindField(v reflect.Value, field reflect.StructField, name string, values []string, fields map[string]string) error {
+	layout := field.Tag.Get("layout")
+	var nonEmpty []string
+	for _, s := range values {
+		if strings.TrimSpace(s) != "" {
+			nonEmpty = append(nonEmpty, s)
+		}
+	}
+
+	target := v
+	if target.Kind() == reflect.Pointer && len(nonEmpty) > 0 {
+		target.Set(reflect.New(target.Type().Elem()))
+		target = target.Elem()
+	}
+	isList := target.Kind() == reflect.Slice && !isTextUnmarshaler(target) && !isBytes(target)
+	if isList && len(nonEmpty) > 0 {
+		slice := reflect.MakeSlice(target.Type(), len(nonEmpty), len(nonEmpty))
+		for i, s := range nonEmpty {
+			msg, err := setValue(slice.Index(i), s, layout)
+			if err != nil {
+				return fmt.Errorf("%w: field %s: %w", errFormDefinition, field.Name, err)
+			}
+			if msg != "" {
+				fields[name] = msg
+				return nil
+			}
+		}
+		target.Set(slice)
+	} else if !isList && target.Kind() != reflect.Pointer && len(nonEmpty) > 0 {
+		msg, err := setValue(target, nonEmpty[len(nonEmpty)-1], layout)
+		if err != nil {
+			return fmt.Errorf("%w: field %s: %w", errFormDefinition, field.Name, err)
+		}
+		if msg != "" {
+			fields[name] = msg
+			return nil
+		}
+	}
+
+	rules := field.Tag.Get("validate")
+	if rules == "" {
+		return nil
+	}
+	msg, err := validateField(target, nonEmpty, rules)
+	if err != nil {
+		return fmt.Errorf("%w: field %s: %w", errFormDefinition, field.Name, err)
+	}
+	if msg != "" {
+		fields[name] = msg
+	}
+	return nil
+}
+
+var textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+
+func isTextUnmarshaler(v reflect.Value) bool {
+	return reflect.PointerTo(v.Type()).Implements(textUnmarshalerType)
+}
+
+// isBytes returns true for []byte fields, which are set like strings.
+func isBytes(v reflect.Value) bool {
+	return v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8
+}
+
+// formTimeLayouts are the formats of the date and time inputs of
+// browsers, which are tried if the field has no layout tag.
+var formTimeLayouts = []string{
+	"2006-01-02",
+	"2006-01-02T15:04",
+	"2006-01-02T15:04:05",
+	time.RFC3339,
+	"15:04",
+	"15:04:05",
+}
+
+// setValue converts s to the type of v and sets it. It returns a message
+// for the user if s is invalid, or an error if the type is not supported.
+func setValue(v reflect.Value, s string, layout string) (string, error) {
+	if v.Kind() == reflect.Pointer {
+		v.Set(reflect.New(v.Type().Elem()))
+		v = v.Elem()
+	}
+	if v.Type() == reflect.TypeOf(time.Time{}) {
+		layouts := formTimeLayouts
+		if layout != "" {
+			layouts = []string{layout}
+		}
+		for _, l := range layouts {
+			t, err := time.Parse(l, s)
+			if err == nil {
+				v.Set(reflect.ValueOf(t))
+				return "", nil
+			}
+		}
+		return "must be a valid date", nil
+	}
+	if isTextUnmarshaler(v) {
+		err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s))
+		if err != nil {
+			return "is invalid", nil
+		}
+		return "", nil
+	}
+	if isBytes(v) {
+		v.SetBytes([]byte(s))
+		return "", nil
+	}
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		switch strings.ToLower(s) {
+		case "on", "true", "1", "yes", "checked":
+			v.SetBool(true)
+		case "off", "false", "0", "no":
+			v.SetBool(false)
+		default:
+			return "must be yes or no", nil
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(strings.TrimSpace(s), 10, v.Type().Bits())
+		if err != nil {
+			return "must be a whole number", nil
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(strings.TrimSpace(s), 10, v.Type().Bits())
+		if err != nil {
+			return "must be a positive whole number", nil
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(strings.TrimSpace(s), v.Type().Bits())
+		if err != nil {
+			return "must be a number", nil
+		}
+		v.SetFloat(n)
+	default:
+		return "", fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return "", nil
+}
+
+// validateField checks the rules of the validate tag and returns the message
+// for the first rule that failed, or an error if a rule is malformed.
+func validateField(v reflect.Value, values []string, rules string) (string, error) {
+	for _, rule := range strings.Split(rules, ",") {
+		rule, param, _ := strings.Cut(strings.TrimSpace(rule), "=")
+		switch rule {
+		case "required":
+			if len(values) == 0 || (v.Kind() == reflect.Bool && !v.Bool()) {
+				return "is required", nil
+			}
+		case "min", "max":
+			if len(values) == 0 {
+				continue
+			}
+			limit, err := strconv.ParseFloat(param, 64)
+			if err != nil {
+				return "", fmt.Errorf("invalid %s rule %q", rule, param)
+			}
+			n, unit := measure(v)
+			if rule == "min" && n < limit {
+				return limitMessage("at least", param, unit), nil
+			}
+			if rule == "max" && n > limit {
+				return limitMessage("at most", param, unit), nil
+			}
+		case "email":
+			for _, s := range values {
+				addr, err := mail.ParseAddress(s)
+				if err != nil || addr.Name != "" || addr.Address != strings.TrimSpace(s) {
+					return "must be a valid email address", nil
+				}
+			}
+		case "oneof":
+			options := strings.Split(param, "|")
+			for _, s := range values {
+				if !slices.Contains(options, s) {
+					return "must be one of " + strings.Join(options, ", "), nil
+				}
+			}
+		case "":
+		default:
+			return "", fmt.Errorf("unknown validation rule %q", rule)
+		}
+	}
+	return "", nil
+}
+
+// measure returns the number that min and max rules are checked against,
+// and its unit, which is "characters" for strings and []byte, "items" for
+// other slices and empty for numbers.
+func measure(v reflect.Value) (n float64, unit string) {
+	if isBytes(v) {
+		return float64(utf8.RuneCount(v.Bytes())), "characters"
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return float64(utf8.RuneCountInString(v.String())), "characters"
+	case reflect.Slice:
+		return float64(v.Len()), "items"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), ""
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), ""
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), ""
+	}
+	return 0, ""
+}
+
+// limitMessage returns the message for a failed min or max rule,
+// like "must be at least 3 characters long" or "must have at most 5 items".
+func limitMessage(bound, param, unit string) string {
+	if param == "1" {
+		unit = strings.TrimSuffix(unit, "s")
+	}
+	switch unit {
+	case "characters", "character":
+		return fmt.Sprintf("must be %s %s %s long", bound, param, unit)
+	case "items", "item":
+		return fmt.Sprintf("must have %s %s %s", bound, param, unit)
+	}
+	return fmt.Sprintf("must be %s %s", bound, param)
+}
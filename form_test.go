@@ -0,0 +1,206 @@
+package guiapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testAddress struct {
+	City string `form:"city"`
+}
+
+type testForm struct {
+	testAddress
+	Name     string    `form:"name"`
+	Age      int       `form:"age"`
+	Score    float64   `form:"score"`
+	Count    uint8     `form:"count"`
+	Terms    bool      `form:"terms"`
+	News     bool      `form:"news"`
+	Nickname *string   `form:"nickname"`
+	Limit    *int      `form:"limit"`
+	Topics   []string  `form:"topics"`
+	IDs      []int     `form:"ids"`
+	Date     time.Time `form:"date"`
+	Local    time.Time `form:"local"`
+	Custom   time.Time `form:"custom" layout:"02.01.2006"`
+	Data     []byte    `form:"data"`
+	Skipped  string    `form:"-"`
+	Untagged string
+	private  string
+}
+
+func TestBindForm(t *testing.T) {
+	args := `{
+		"city": "Vienna",
+		"name": "Ada",
+		"age": "36",
+		"score": "9.5",
+		"count": 7,
+		"terms": "on",
+		"news": "false",
+		"nickname": "ada",
+		"limit": "",
+		"topics": ["news", "", "updates"],
+		"ids": ["1", "2"],
+		"date": "2024-03-01",
+		"local": "2024-03-01T12:30",
+		"custom": "01.03.2024",
+		"data": "abc",
+		"Skipped": "x",
+		"-": "x",
+		"Untagged": "untagged",
+		"private": "x"
+	}`
+	var form testForm
+	err := BindForm(json.RawMessage(args), &form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nickname := "ada"
+	want := testForm{
+		testAddress: testAddress{City: "Vienna"},
+		Name:        "Ada",
+		Age:         36,
+		Score:       9.5,
+		Count:       7,
+		Terms:       true,
+		Nickname:    &nickname,
+		Topics:      []string{"news", "updates"},
+		IDs:         []int{1, 2},
+		Date:        time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		Local:       time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC),
+		Custom:      time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC),
+		Data:        []byte("abc"),
+		Untagged:    "untagged",
+	}
+	if !reflect.DeepEqual(form, want) {
+		t.Errorf("got form\n%+v\nwant\n%+v", form, want)
+	}
+}
+
+func TestBindFormInvalid(t *testing.T) {
+	type form struct {
+		Name   string    `form:"name" validate:"required,min=3,max=5"`
+		Email  string    `form:"email" validate:"email"`
+		Color  string    `form:"color" validate:"oneof=red|green"`
+		Age    int       `form:"age" validate:"min=18,max=99"`
+		Terms  bool      `form:"terms" validate:"required"`
+		Topics []string  `form:"topics" validate:"min=2,max=3"`
+		Tags   []string  `form:"tags" validate:"max=1"`
+		Data   []byte    `form:"data" validate:"max=3"`
+		Count  uint      `form:"count"`
+		Ratio  float64   `form:"ratio"`
+		Flag   bool      `form:"flag"`
+		Date   time.Time `form:"date"`
+	}
+	cases := []struct {
+		name  string
+		args  string
+		field string
+		want  string
+	}{
+		{"required missing", `{}`, "name", "is required"},
+		{"required blank", `{"name":"  "}`, "name", "is required"},
+		{"too short", `{"name":"ab"}`, "name", "must be at least 3 characters long"},
+		{"too long", `{"name":"abcdef"}`, "name", "must be at most 5 characters long"},
+		{"characters not bytes", `{"name":"äöüäö","terms":"on"}`, "", ""},
+		{"email", `{"name":"abc","email":"not an email"}`, "email", "must be a valid email address"},
+		{"email with name", `{"name":"abc","email":"Ada <ada@example.com>"}`, "email", "must be a valid email address"},
+		{"valid email", `{"name":"abc","email":"ada@example.com","terms":"on"}`, "", ""},
+		{"oneof", `{"name":"abc","color":"blue"}`, "color", "must be one of red, green"},
+		{"number too small", `{"name":"abc","age":"17"}`, "age", "must be at least 18"},
+		{"number too large", `{"name":"abc","age":"100"}`, "age", "must be at most 99"},
+		{"not a number", `{"name":"abc","age":"old"}`, "age", "must be a whole number"},
+		{"checkbox required", `{"name":"abc","terms":"off"}`, "terms", "is required"},
+		{"too few items", `{"name":"abc","topics":["a"]}`, "topics", "must have at least 2 items"},
+		{"too many items", `{"name":"abc","topics":["a","b","c","d"]}`, "topics", "must have at most 3 items"},
+		{"single item", `{"name":"abc","tags":["a","b"]}`, "tags", "must have at most 1 item"},
+		{"bytes too long", `{"name":"abc","data":"abcd"}`, "data", "must be at most 3 characters long"},
+		{"negative uint", `{"name":"abc","count":"-1"}`, "count", "must be a positive whole number"},
+		{"not a float", `{"name":"abc","ratio":"half"}`, "ratio", "must be a number"},
+		{"not a bool", `{"name":"abc","flag":"maybe"}`, "flag", "must be yes or no"},
+		{"not a date", `{"name":"abc","date":"yesterday"}`, "date", "must be a valid date"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			var f form
+			err := BindForm(json.RawMessage(c.args), &f)
+			if c.field == "" {
+				if err != nil {
+					t.Fatalf("got error %v, want nil", err)
+				}
+				return
+			}
+			var e *Error
+			if !errors.As(err, &e) || e.Code != "validation" {
+				t.Fatalf("got error %v, want a validation error", err)
+			}
+			if e.Fields[c.field] != c.want {
+				t.Errorf("got messages %v, want %q for %s", e.Fields, c.want, c.field)
+			}
+		})
+	}
+}
+
+func TestBindFormDefinition(t *testing.T) {
+	cases := []struct {
+		name string
+		dst  any
+	}{
+		{"not a pointer", struct{}{}},
+		{"unsupported type", &struct {
+			Values map[string]string `form:"values"`
+		}{}},
+		{"malformed rule", &struct {
+			Name string `form:"name" validate:"min=few"`
+		}{}},
+		{"unknown rule", &struct {
+			Name string `form:"name" validate:"shiny"`
+		}{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := BindForm(json.RawMessage(`{"values":"a","name":"b"}`), c.dst)
+			if !errors.Is(err, errFormDefinition) {
+				t.Errorf("got error %v, want errFormDefinition", err)
+			}
+		})
+	}
+}
+
+func TestFormActionDefinitionError(t *testing.T) {
+	var reported error
+	s := New(WithoutCSRF(), WithErrorReporter(func(r *http.Request, err error) {
+		reported = err
+	}))
+	type form struct {
+		Values map[string]string `form:"values"`
+	}
+	AddFormAction(s, "Save", func(c *ActionCtx, f *form, state *struct{}) (*Update, error) {
+		return nil, nil
+	})
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest("POST", "/guiapi", strings.NewReader(`{"Name":"Save","Args":{"values":"a"}}`)))
+
+	var res Update
+	err := json.Unmarshal(w.Body.Bytes(), &res)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Error == nil || res.Error.Code != "internal" || strings.Contains(res.Error.Message, "map") {
+		t.Errorf("got error %+v, want an internal error without details", res.Error)
+	}
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %d, want 500", w.Code)
+	}
+	if !errors.Is(reported, errFormDefinition) {
+		t.Errorf("reported %v, want errFormDefinition", reported)
+	}
+}
@@ -1,14 +1,15 @@
 module github.com/mbertschler/guiapi
 
-go 1.18
+go 1.21
 
 require (
 	github.com/evanw/esbuild v0.17.19
 	github.com/julienschmidt/httprouter v1.3.0
+	golang.org/x/net v0.17.0
 	nhooyr.io/websocket v1.8.7
 )
 
 require (
 	github.com/klauspost/compress v1.10.3 // indirect
-	golang.org/x/sys v0.0.0-20220715151400-c0bba94af5f8 // indirect
+	golang.org/x/sys v0.13.0 // indirect
 )
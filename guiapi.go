@@ -1,11 +1,17 @@
 package guiapi
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
 	"net/http"
 	"net/url"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
 
 	"github.com/mbertschler/guiapi/api"
 )
@@ -25,28 +31,108 @@ type action struct {
 
 // handle handles HTTP requests to the GUI API.
 func (s *Server) handle(c *PageCtx) {
+	err := s.checkCSRF(c.Request)
+	if err != nil {
+		s.rejectCSRF(c)
+		return
+	}
+	body := c.Request.Body
+	if s.opts.MaxBodySize > 0 {
+		body = http.MaxBytesReader(c.Writer, body, s.opts.MaxBodySize)
+	}
+	buf, err := io.ReadAll(body)
+	if err != nil {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			s.logger.Warn("guiapi: request too large", "limit", maxErr.Limit)
+			s.writeError(c.Writer, errTooLarge)
+			return
+		}
+		s.logger.Warn("guiapi: error reading request", "error", err)
+		s.writeError(c.Writer, fmt.Errorf("%w: %v", errInvalidRequest, err))
+		return
+	}
 	var req action
-	err := json.NewDecoder(c.Request.Body).Decode(&req)
+	err = checkJSONDepth(buf, s.opts.MaxJSONDepth)
+	if err == nil {
+		err = json.Unmarshal(buf, &req)
+	}
 	if err != nil {
-		log.Println("guiapi: error decoding request:", err)
+		s.logger.Warn("guiapi: error decoding request", "error", err)
+		if !errors.Is(err, errInvalidRequest) {
+			err = fmt.Errorf("%w: %v", errInvalidRequest, err)
+		}
+		s.writeError(c.Writer, err)
 		return
 	}
 	if req.URL != "" {
 		s.processURL(c, &req)
 		return
 	}
-	resp := s.process(c, &req)
-	err = json.NewEncoder(c.Writer).Encode(resp)
+	res := s.process(c, &req)
+	s.writeUpdate(c.Writer, errorStatus(res.Error), res)
+}
+
+// writeUpdate sends the Update as JSON response with the HTTP status.
+// All responses of the action endpoint are sent as Updates, so that
+// the browser can handle errors the same way as successful responses.
+func (s *Server) writeUpdate(w http.ResponseWriter, status int, u *Update) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(u)
 	if err != nil {
-		log.Println("guiapi: error encoding response:", err)
-		return
+		s.logger.Warn("guiapi: error encoding response", "action", u.Name, "error", err)
+	}
+}
+
+// writeError sends an Update that only contains the error as
+// JSON response, with the HTTP status that matches the error.
+func (s *Server) writeError(w http.ResponseWriter, err error) {
+	apiErr := errorFromErr(err)
+	s.writeUpdate(w, errorStatus(apiErr), &Update{Error: apiErr})
+}
+
+// errorStatus returns the HTTP status for an Update with the passed error.
+// Errors that an ActionFunc returned are a normal result of the action and
+// use http.StatusOK, errors of the request itself use a matching status.
+func errorStatus(err *api.Error) int {
+	if err == nil {
+		return http.StatusOK
+	}
+	switch err.Code {
+	case "invalidRequest", "invalidArgs", "invalidState", "tamperedState", "stateExpired":
+		return http.StatusBadRequest
+	case "csrf", "forbidden":
+		return http.StatusForbidden
+	case "undefinedFunction", "notFound":
+		return http.StatusNotFound
+	case "requestTooLarge":
+		return http.StatusRequestEntityTooLarge
+	case "rateLimited":
+		return http.StatusTooManyRequests
+	case "internal":
+		return http.StatusInternalServerError
+	case "notUpdateable":
+		return http.StatusNotImplemented
+	case "timeout":
+		return http.StatusGatewayTimeout
 	}
+	return http.StatusOK
 }
 
 func (s *Server) process(p *PageCtx, req *action) *Update {
 	var res = Update{
 		Name: req.Name,
 	}
+	start := time.Now()
+	defer func() {
+		if res.Error != nil {
+			s.logger.Warn("action error", "action", req.Name, "duration", time.Since(start),
+				"code", res.Error.Code, "error", res.Error.Message)
+			return
+		}
+		s.logger.Debug("action", "action", req.Name, "duration", time.Since(start), s.argsAttr(req.Args))
+	}()
 
 	action, ok := s.actions[req.Name]
 	if !ok {
@@ -54,57 +140,151 @@ func (s *Server) process(p *PageCtx, req *action) *Update {
 			Code:    "undefinedFunction",
 			Message: fmt.Sprint(req.Name, " is not defined"),
 		}
-	} else {
-		actionCtx := ActionCtx{
-			Writer:  p.Writer,
-			Request: p.Request,
-			State:   req.State,
-			Args:    req.Args,
-		}
-		r, err := action(&actionCtx)
-		if err != nil {
-			res.Error = &api.Error{
-				Code:    "error",
-				Message: err.Error(),
-			}
-		}
-		if r != nil {
-			if err == nil {
-				res.Error = r.Error
-			}
-			res = *r
-			res.Name = req.Name
+		return &res
+	}
+	if s.limiter != nil {
+		key := s.opts.SessionKey(p.Request)
+		if !s.limiter.acquire(key) {
+			res.Error = errorFromErr(errRateLimited)
+			return &res
 		}
+		defer s.limiter.release(key)
+	}
+	state, stateInvalid, err := s.decodeState(p.Request, req.State)
+	if err != nil {
+		res.Error = errorFromErr(err)
+		return &res
 	}
-	return &res
+	ctx := p.Request.Context()
+	if s.opts.ActionTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.opts.ActionTimeout)
+		defer cancel()
+	}
+
+	actionCtx := ActionCtx{
+		Name:         req.Name,
+		Writer:       p.Writer,
+		Request:      p.Request,
+		State:        state,
+		Args:         req.Args,
+		StateInvalid: stateInvalid,
+		ctx:          ctx,
+	}
+	var r *Update
+	err = s.safely(p.Request, fmt.Sprintf("action %q", req.Name), func() error {
+		var err error
+		r, err = chain(action, s.middleware)(&actionCtx)
+		return timeoutErr(ctx, err)
+	})
+	if r != nil {
+		res = *r
+		res.Name = req.Name
+	}
+	if errors.Is(err, errFormDefinition) {
+		s.reportError(p.Request, fmt.Errorf("action %q: %w", req.Name, err))
+		err = errInternal
+	}
+	if err != nil {
+		res.Error = errorFromErr(err)
+	}
+	return s.encodeState(p.Request, &res)
 }
 
 func (s *Server) processURL(c *PageCtx, req *action) {
+	_, _, err := s.decodeState(c.Request, req.State)
+	if err != nil {
+		s.writeError(c.Writer, err)
+		return
+	}
+
 	url, err := url.Parse(req.URL)
 	if err != nil {
-		log.Println("guiapi: error parsing url:", err)
-		c.Writer.WriteHeader(400)
-		c.Writer.Write([]byte(`{"error":"400 bad request"}`))
+		s.logger.Warn("guiapi: error parsing url", "url", req.URL, "error", err)
+		s.writeError(c.Writer, fmt.Errorf("%w: %v", errInvalidRequest, err))
 		return
 	}
-	handle, params, _ := s.pagesRouter.Lookup("GET", url.Path)
+	var handle httprouter.Handle
+	var params httprouter.Params
+	if path, ok := s.pagePath(url.Path); ok {
+		handle, params, _ = s.pagesRouter.Lookup("GET", path)
+	}
 	if handle == nil {
-		log.Println("guiapi: no handler found for", req.URL)
-		c.Writer.WriteHeader(404)
-		c.Writer.Write([]byte(`{"error":"404 page not found"}`))
+		s.logger.Info("guiapi: no page found", "url", req.URL)
+		s.writeError(c.Writer, errPageNotFound)
 		return
 	}
 	handle(c.Writer, c.Request, params)
 }
 
+// pagePath returns the path of the page below the base path for the URL
+// path, or false if it is not below the base path. The base path itself
+// is the root page, like it is for full page loads.
+func (s *Server) pagePath(urlPath string) (string, bool) {
+	path, ok := strings.CutPrefix(urlPath, s.opts.BasePath)
+	switch {
+	case !ok:
+		return "", false
+	case path == "":
+		return "/", true
+	case !strings.HasPrefix(path, "/"):
+		// like /administrator for the base path /admin
+		return "", false
+	}
+	return path, true
+}
+
 // ActionCtx is the context that is passed to an ActionFunc.
 // It extends the Request and Writer from a typical HTTP request handler with
 // State and Args fields from the Action call that were sent from the browser.
+//
+// If the action was called via the WebSocket connection, Request is the
+// request that opened the connection, and anything written to Writer,
+// including headers like cookies, is discarded.
 type ActionCtx struct {
+	Name    string // name of the called action
 	Writer  http.ResponseWriter
 	Request *http.Request
 	State   json.RawMessage
 	Args    json.RawMessage
+
+	// StateInvalid is set if the State sent from the browser was tampered
+	// with and Options.FlagInvalidState is set. State is nil in this case.
+	StateInvalid bool
+
+	ctx context.Context
+}
+
+// Context returns the context of the action call. It is canceled when
+// the client disconnects or when the timeout of the action is reached,
+// see Options.ActionTimeout and Timeout(). Long running actions should
+// pass it to database queries and similar calls.
+func (c *ActionCtx) Context() context.Context {
+	if c.ctx != nil {
+		return c.ctx
+	}
+	return context.Background()
+}
+
+// WithContext returns a shallow copy of the ActionCtx with its context
+// changed to ctx. It can be used by Middleware to pass values or
+// deadlines to the next ActionFunc.
+func (c *ActionCtx) WithContext(ctx context.Context) *ActionCtx {
+	c2 := *c
+	c2.ctx = ctx
+	return &c2
+}
+
+// timeoutErr adds context.DeadlineExceeded to err if ctx timed out, so that
+// an error like a failed database query is reported as a timeout.
+func timeoutErr(ctx context.Context, err error) error {
+	if err == nil || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return errors.Join(err, context.DeadlineExceeded)
+	}
+	return err
 }
 
 // ActionFunc is the action handler function that should return an Update in
@@ -1,4 +1,5 @@
-import { handleStream } from "./websocket.js"
+import { morph } from "./morph.js"
+import { handleStreams, sendAction, setStreamURLs, subscribe, unsubscribe, websocketURL } from "./websocket.js"
 
 export var callableFunctions = {}
 
@@ -22,6 +23,25 @@ var state = null
 
 let debugGuiapi = false
 
+// if enabled, actions are sent via an open WebSocket connection
+let websocketActions = false
+
+// actionURL is the endpoint for actions and page requests
+let actionURL = "/guiapi"
+
+// errorURL is the endpoint that errors like missing targets of
+// required HTML updates are reported to
+let errorURL = "/guiapi/error"
+
+// requestTimeout is the number of milliseconds after which an action
+// or page request fails with a timeout error, 0 means no timeout
+let requestTimeout = 0
+
+// csrfToken is sent with every request to the action endpoint. If it is
+// not passed to setupGuiapi, it is read from the guiapi-csrf meta tag
+// or the guiapi_csrf cookie.
+let csrfToken = null
+
 export function debugPrinting(enable) {
     debugGuiapi = enable
 }
@@ -35,6 +55,11 @@ export function action(name, args, callback) {
         Args: args,
         State: state,
     }
+    // the errors of the previous call are replaced by the response
+    clearFieldErrors()
+    if (websocketActions && sendAction(req, callback || (() => { }), requestTimeout)) {
+        return
+    }
     guiapiRequest(req, callback)
 }
 
@@ -53,22 +78,187 @@ function guiapiRequest(req, callback) {
     if (!callback) {
         callback = () => { }
     }
-    fetch("/guiapi", {
+    const controller = new AbortController()
+    let timer = null
+    if (requestTimeout > 0) {
+        timer = setTimeout(() => controller.abort(), requestTimeout)
+    }
+    fetch(actionURL, {
         method: 'POST',
+        signal: controller.signal,
         mode: 'cors',
         credentials: 'same-origin',
-        headers: { 'Content-Type': 'application/json' },
+        headers: {
+            'Content-Type': 'application/json',
+            'X-Guiapi-Csrf': getCSRFToken(),
+        },
         body: JSON.stringify(req)
     }).then((response) => {
-        response.json().then(r => {
+        clearTimeout(timer)
+        response.json().catch((reason) => {
+            console.error('response.json() error:', reason)
+            return null
+        }).then(r => {
             if (debugGuiapi) {
-                console.log("guiapi response:", r)
+                console.log("guiapi response:", response.status, r)
+            }
+            if (!r || (!response.ok && !r.Error)) {
+                // the response is not an Update, for example an
+                // error page of a proxy in front of the server
+                r = {
+                    Error: {
+                        Code: "invalidResponse",
+                        Message: "unexpected response: " + response.status + " " + response.statusText,
+                    },
+                }
             }
             handleResponse(r, callback)
-        }).catch(r => console.error('response.json() error:', r))
+        })
     }).catch((reason) => {
+        clearTimeout(timer)
+        if (reason.name === "AbortError") {
+            handleResponse({ Error: timeoutError() }, callback)
+            return
+        }
         console.error('fetch() error:', reason)
-        callback(reason)
+        handleResponse({
+            Error: {
+                Code: "networkError",
+                Message: String(reason),
+                Retryable: true,
+            },
+        }, callback)
+    })
+}
+
+// timeoutError is the error of requests that didn't get
+// a response in time. It has the same code as server side
+// timeouts, so the errorHandler can handle both the same way.
+export function timeoutError() {
+    return {
+        Code: "timeout",
+        Message: "the request timed out",
+        Retryable: true,
+    }
+}
+
+function getCSRFToken() {
+    if (csrfToken) {
+        return csrfToken
+    }
+    const meta = document.querySelector('meta[name="guiapi-csrf"]')
+    if (meta) {
+        return meta.content
+    }
+    // the server sets the cookie for every page
+    for (const cookie of document.cookie.split(";")) {
+        const [name, value] = cookie.trim().split("=")
+        if (name === "guiapi_csrf") {
+            return value
+        }
+    }
+    return ""
+}
+
+// setValue sets the value of a form input. Checkboxes and radio
+// buttons are checked if the value is "true" or their own value.
+function setValue(el, value) {
+    if (el.type === "checkbox" || el.type === "radio") {
+        el.checked = value === "true" || value === el.value
+        return
+    }
+    el.value = value
+}
+
+// selectTargets returns the elements that the HTML update applies to,
+// all matching elements if All is set, otherwise only the first one.
+function selectTargets(update) {
+    try {
+        if (update.All) {
+            return Array.from(document.querySelectorAll(update.Selector))
+        }
+        const el = document.querySelector(update.Selector)
+        return el ? [el] : []
+    } catch (e) {
+        console.error("invalid update selector", update.Selector, e)
+        return []
+    }
+}
+
+function applyHTMLUpdate(el, update) {
+    switch (update.Operation) {
+        case 1:
+            el.innerHTML = update.Content
+            break
+        case 2:
+            el.outerHTML = update.Content
+            break
+        case 3:
+            el.insertAdjacentHTML('beforebegin', update.Content)
+            break
+        case 4:
+            el.insertAdjacentHTML('afterend', update.Content)
+            break
+        case 5:
+            el.remove()
+            break
+        case 6:
+            el.insertAdjacentHTML('beforeend', update.Content)
+            break
+        case 7:
+            el.insertAdjacentHTML('afterbegin', update.Content)
+            break
+        case 8:
+            el.setAttribute(update.Name, update.Content || "")
+            break
+        case 9:
+            el.removeAttribute(update.Name)
+            break
+        case 10:
+            el.classList.add(update.Name)
+            break
+        case 11:
+            el.classList.remove(update.Name)
+            break
+        case 12:
+            el.classList.toggle(update.Name)
+            break
+        case 13:
+            setValue(el, update.Content || "")
+            break
+        case 14:
+            morph(el, update.Content || "")
+            break
+        default:
+            console.warn("update type not implemented :(", update)
+    }
+}
+
+// reportedErrors prevents that the same error is reported again and again,
+// for example by a stream that sends the same update every second
+const reportedErrors = new Set()
+
+// reportClientError sends the error to the error endpoint of the server,
+// which passes it to the ErrorReporter.
+function reportClientError(err) {
+    err.Page = window.location.href
+    console.error("[" + err.Code + "]", err.Message, err)
+    const key = JSON.stringify([err.Code, err.Selector, err.Page])
+    if (reportedErrors.has(key)) {
+        return
+    }
+    reportedErrors.add(key)
+    fetch(errorURL, {
+        method: 'POST',
+        credentials: 'same-origin',
+        keepalive: true,
+        headers: {
+            'Content-Type': 'application/json',
+            'X-Guiapi-Csrf': getCSRFToken(),
+        },
+        body: JSON.stringify(err),
+    }).catch((reason) => {
+        console.error('error reporting failed:', reason)
     })
 }
 
@@ -78,6 +268,9 @@ export function handleResponse(r, callback) {
     }
     if (r.Error) {
         console.error("[" + r.Error.Code + "]", r.Error.Message, r.Error)
+        if (r.Error.Fields) {
+            showFieldErrors(r.Error.Fields)
+        }
         errorHandler(r.Error)
         callback(r.Error)
         return
@@ -85,27 +278,22 @@ export function handleResponse(r, callback) {
     if (r.HTML) {
         for (var j = 0; j < r.HTML.length; j++) {
             var update = r.HTML[j]
-            const el = document.querySelector(update.Selector)
-            if (!el) {
-                console.warn("update selector not found :(", update.Selector, update)
+            const elements = selectTargets(update)
+            if (elements.length === 0) {
+                if (update.Required) {
+                    reportClientError({
+                        Code: "missingTarget",
+                        Message: "no element matches the selector of a required update",
+                        Selector: update.Selector,
+                        Operation: update.Operation,
+                    })
+                } else {
+                    console.warn("update selector not found :(", update.Selector, update)
+                }
                 continue
             }
-
-            switch (update.Operation) {
-                case 1:
-                    el.innerHTML = update.Content
-                    break
-                case 2:
-                    el.outerHTML = update.Content
-                    break
-                case 3:
-                    el.insertAdjacentHTML('beforebegin', update.Content)
-                    break
-                case 4:
-                    el.insertAdjacentHTML('afterend', update.Content)
-                    break
-                default:
-                    console.warn("update type not implemented :(", update)
+            for (const el of elements) {
+                applyHTMLUpdate(el, update)
             }
         }
     }
@@ -121,15 +309,68 @@ export function handleResponse(r, callback) {
         }
     }
     if (r.Stream) {
-        for (var i = 0; i < r.Stream.length; i++) {
-            handleStream(r.Stream[i])
-        }
+        handleStreams(r.Stream)
     }
     if (r.URL) {
         addPageToHistory(r.URL)
     }
+    if (r.Title) {
+        document.title = r.Title
+    }
+    if (r.Meta) {
+        for (const tag of r.Meta) {
+            setMeta(tag.Name, tag.Content)
+        }
+    }
     hydrate()
     callback(null)
+    if (r.Navigate) {
+        navigate(r.Navigate)
+    }
+}
+
+// setMeta sets the content of the meta tag with the name,
+// and creates the tag in the document head if it doesn't exist.
+function setMeta(name, content) {
+    let meta = document.querySelector('meta[name="' + CSS.escape(name) + '"]')
+    if (!meta) {
+        meta = document.createElement("meta")
+        meta.name = name
+        document.head.appendChild(meta)
+    }
+    meta.content = content
+}
+
+// navigate runs the navigation of an Update, after the rest
+// of the Update was applied and the callback was called.
+function navigate(nav) {
+    switch (nav.Operation) {
+        case 1:
+            guiapiPage(nav.URL, err => {
+                if (!err) {
+                    addPageToHistory(nav.URL)
+                }
+            })
+            break
+        case 2:
+            guiapiPage(nav.URL, err => {
+                if (!err) {
+                    replacePageInHistory(nav.URL)
+                }
+            })
+            break
+        case 3:
+            replacePageInHistory(nav.URL)
+            break
+        case 4:
+            window.location.assign(nav.URL)
+            break
+        case 5:
+            window.location.reload()
+            break
+        default:
+            console.warn("navigation type not implemented :(", nav)
+    }
 }
 
 function hydrate() {
@@ -170,16 +411,11 @@ function hydrateOn(el) {
             selector = el.attributes.getNamedItem("ga-values").value
         }
         el.addEventListener(eventType, function (e) {
+            let actionArgs = args
             if (selector) {
-                if (args == null) {
-                    args = {}
-                }
-                var elements = document.querySelectorAll(selector)
-                for (ele of elements) {
-                    args[ele.name] = ele.value
-                }
+                actionArgs = gatherValues(selector, args)
             }
-            action(actionName, args)
+            action(actionName, actionArgs)
             e.preventDefault()
             e.stopPropagation()
             return false
@@ -188,6 +424,70 @@ function hydrateOn(el) {
     el.classList.remove("ga")
 }
 
+// gatherValues returns a copy of args that also contains the values of all
+// inputs that match the selector or are inside of an element that matches it.
+// Unchecked checkboxes and radio buttons are skipped, multiple checked
+// checkboxes with the same name and multi-selects are gathered into a list.
+function gatherValues(selector, args) {
+    const values = (args && typeof args === "object") ? { ...args } : {}
+    for (const el of document.querySelectorAll(selector)) {
+        const inputs = el.matches("input, select, textarea") ? [el] : el.querySelectorAll("input, select, textarea")
+        for (const input of inputs) {
+            addValue(values, input)
+        }
+    }
+    return values
+}
+
+function addValue(values, input) {
+    if (!input.name || input.disabled || input.type === "file") {
+        return
+    }
+    if ((input.type === "checkbox" || input.type === "radio") && !input.checked) {
+        return
+    }
+    if (input.type === "select-multiple") {
+        values[input.name] = Array.from(input.selectedOptions, (option) => option.value)
+        return
+    }
+    if (input.type === "checkbox" && input.name in values) {
+        values[input.name] = [].concat(values[input.name], input.value)
+        return
+    }
+    values[input.name] = input.value
+}
+
+// showFieldErrors marks the inputs of the invalid fields with the ga-invalid
+// class and shows the error messages in a ga-field-error element after them.
+function showFieldErrors(fields) {
+    for (const [name, message] of Object.entries(fields)) {
+        const inputs = document.querySelectorAll('[name="' + CSS.escape(name) + '"]')
+        if (inputs.length === 0) {
+            console.warn("no input found for field error:", name, message)
+            continue
+        }
+        for (const input of inputs) {
+            input.classList.add("ga-invalid")
+            input.setAttribute("aria-invalid", "true")
+        }
+        const error = document.createElement("span")
+        error.className = "ga-field-error"
+        error.textContent = message
+        inputs[inputs.length - 1].insertAdjacentElement("afterend", error)
+    }
+}
+
+// clearFieldErrors removes all field errors that were shown before.
+function clearFieldErrors() {
+    for (const el of document.querySelectorAll(".ga-field-error")) {
+        el.remove()
+    }
+    for (const el of document.querySelectorAll(".ga-invalid")) {
+        el.classList.remove("ga-invalid")
+        el.removeAttribute("aria-invalid")
+    }
+}
+
 function hydrateInit(el) {
     var initFunc = el.attributes.getNamedItem("ga-init").value
     var args = null
@@ -232,12 +532,12 @@ function addPageToHistory(url) {
     if (originalState === null) {
         originalState = {
             url: window.location.pathname + window.location.search,
-            oldState: { ...state },
+            oldState: state,
         }
     }
     const pushedState = {
         url,
-        oldState: { ...state },
+        oldState: state,
     }
     window.history.pushState(pushedState, "", url)
 }
@@ -245,22 +545,62 @@ function addPageToHistory(url) {
 let errorHandler = (err) => { }
 
 export function setupGuiapi(options) {
+    setupEndpoints(options || {})
     if (options && options.debug) {
         debugGuiapi = true
     }
     if (options && options.errorHandler) {
         errorHandler = options.errorHandler
     }
+    if (options && options.websocketActions) {
+        websocketActions = true
+    }
+    if (options && options.timeout) {
+        requestTimeout = options.timeout
+    }
+    if (options && options.csrfToken) {
+        csrfToken = options.csrfToken
+    }
     if (options.state) {
         state = options.state
     }
     if (options.stream) {
-        handleStream(options.stream)
+        handleStreams([options.stream])
+    }
+    if (options.streams) {
+        handleStreams(options.streams)
     }
     hydrate()
     setupHistory()
 }
 
+// setupEndpoints derives the endpoint URLs from the basePath that the
+// server is mounted under and the current page location. Each of the
+// URLs can also be set explicitly, which is needed if the server changed
+// the paths of its endpoints with WithPaths().
+function setupEndpoints(options) {
+    const basePath = (options.basePath || "").replace(/\/$/, "")
+    actionURL = options.actionURL || basePath + "/guiapi"
+    errorURL = options.errorURL || basePath + "/guiapi/error"
+    setStreamURLs(
+        options.websocketURL || websocketURL(basePath + "/guiapi/ws"),
+        options.sseURL || basePath + "/guiapi/sse",
+    )
+}
+
+// replacePageInHistory replaces the current history entry,
+// so that the back button skips the page that was shown before.
+function replacePageInHistory(url) {
+    const replacedState = {
+        url,
+        oldState: state,
+    }
+    if (originalState === null) {
+        originalState = replacedState
+    }
+    window.history.replaceState(replacedState, "", url)
+}
+
 function setupHistory() {
     window.addEventListener("popstate", function (e) {
         let s = e.state
@@ -275,9 +615,13 @@ function setupHistory() {
     })
 }
 
+export { subscribe, unsubscribe }
+
 export default {
     action,
     setupGuiapi,
     registerFunctions,
-    debugPrinting
+    debugPrinting,
+    subscribe,
+    unsubscribe,
 }
\ No newline at end of file
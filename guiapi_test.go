@@ -0,0 +1,68 @@
+package guiapi
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testPage struct {
+	title string
+}
+
+func (p testPage) WriteHTML(w io.Writer) error {
+	_, err := io.WriteString(w, p.title)
+	return err
+}
+
+func (p testPage) Update() (*Update, error) {
+	return &Update{Title: p.title}, nil
+}
+
+func TestProcessURL(t *testing.T) {
+	cases := []struct {
+		name      string
+		basePath  string
+		url       string
+		wantTitle string
+		wantCode  string
+	}{
+		{name: "root", url: "/", wantTitle: "home"},
+		{name: "page", url: "/reports?sort=name", wantTitle: "reports"},
+		{name: "unknown page", url: "/unknown", wantCode: "notFound"},
+		{name: "base path", basePath: "/admin", url: "/admin", wantTitle: "home"},
+		{name: "base path with slash", basePath: "/admin", url: "/admin/", wantTitle: "home"},
+		{name: "page below base path", basePath: "/admin", url: "/admin/reports", wantTitle: "reports"},
+		{name: "outside of base path", basePath: "/admin", url: "/reports", wantCode: "notFound"},
+		{name: "base path prefix", basePath: "/admin", url: "/administrator", wantCode: "notFound"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := New(WithBasePath(c.basePath), WithoutCSRF())
+			s.AddPage("/", func(*PageCtx) (Page, error) {
+				return testPage{title: "home"}, nil
+			})
+			s.AddPage("/reports", func(*PageCtx) (Page, error) {
+				return testPage{title: "reports"}, nil
+			})
+			body, _ := json.Marshal(action{URL: c.url})
+			w := httptest.NewRecorder()
+			s.ServeHTTP(w, httptest.NewRequest("POST", c.basePath+"/guiapi", strings.NewReader(string(body))))
+
+			var res Update
+			err := json.Unmarshal(w.Body.Bytes(), &res)
+			if err != nil {
+				t.Fatalf("decoding response %s: %v", w.Body, err)
+			}
+			code := ""
+			if res.Error != nil {
+				code = res.Error.Code
+			}
+			if res.Title != c.wantTitle || code != c.wantCode {
+				t.Errorf("got title %q and error code %q, want %q and %q", res.Title, code, c.wantTitle, c.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,156 @@
+package guiapi
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// hubBufferSize is the number of Updates that are buffered for each
+// subscriber. If a subscriber falls further behind, Updates are dropped.
+const hubBufferSize = 16
+
+// Hub is a publish/subscribe hub for stream Updates. Any code can Publish
+// an Update to a topic, and all streams that subscribed to that topic will
+// receive it. Every Server has a Hub, but a Hub can also be used on its own.
+//
+// If the application runs on multiple instances, a Backend can be set
+// with SetBackend(), to also deliver Updates to the other instances.
+type Hub struct {
+	lock    sync.Mutex
+	topics  map[string]map[*hubSubscriber]struct{}
+	backend Backend
+	logger  *slog.Logger
+}
+
+type hubSubscriber struct {
+	updates chan *Update
+}
+
+// NewHub returns a new empty Hub that logs with slog.Default().
+func NewHub() *Hub {
+	return &Hub{
+		topics: map[string]map[*hubSubscriber]struct{}{},
+		logger: slog.Default(),
+	}
+}
+
+// SetLogger sets the logger that is used for backend errors and dropped
+// Updates. The Hub of a Server uses the Logger from the Options.
+func (h *Hub) SetLogger(logger *slog.Logger) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.logger = logger
+}
+
+// Publish sends the Update to all current subscribers of the topic. If
+// a Backend is set, the Update is sent to the subscribers of all instances.
+// If the Backend fails, for example while it reconnects, the Update is
+// still delivered to the subscribers of this instance.
+// Delivering to subscribers never blocks. If a subscriber can't keep up with
+// the published Updates, the Update is dropped for that subscriber.
+func (h *Hub) Publish(topic string, u *Update) {
+	h.lock.Lock()
+	backend := h.backend
+	logger := h.logger
+	h.lock.Unlock()
+	if backend == nil {
+		h.deliver(topic, u)
+		return
+	}
+	err := backend.Publish(topic, u)
+	if err != nil {
+		logger.Error("hub: backend error", "topic", topic, "error", err)
+		h.deliver(topic, u)
+	}
+}
+
+// deliver sends the Update to the subscribers of this Hub.
+func (h *Hub) deliver(topic string, u *Update) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for sub := range h.topics[topic] {
+		select {
+		case sub.updates <- u:
+		default:
+			h.logger.Warn("hub: dropped update for slow subscriber", "topic", topic)
+		}
+	}
+}
+
+// Subscribe forwards all Updates that are published to any of the topics
+// to res, until ctx is canceled. It is meant to be called from a StreamFunc,
+// which can directly return the result:
+//
+//	func(ctx context.Context, args json.RawMessage, res chan<- *guiapi.Update) error {
+//		return hub.Subscribe(ctx, res, "reports")
+//	}
+func (h *Hub) Subscribe(ctx context.Context, res chan<- *Update, topics ...string) error {
+	return h.subscribe(ctx, res, topics, nil)
+}
+
+// SubscribeDiff works like Subscribe, but passes all Updates through a
+// Differ, so that only the changed parts of re-rendered elements are sent
+// to the browser. Updates that don't change anything are skipped. Every call
+// uses its own Differ, because every subscription belongs to one connection.
+func (h *Hub) SubscribeDiff(ctx context.Context, res chan<- *Update, topics ...string) error {
+	differ := NewDiffer()
+	return h.subscribe(ctx, res, topics, func(u *Update) *Update {
+		diffed := differ.Update(u)
+		if len(u.HTML) > 0 && diffed.empty() {
+			return nil
+		}
+		return diffed
+	})
+}
+
+// subscribe forwards the Updates of the topics to res. If filter is set,
+// the Updates are replaced by its result, and skipped if it returns nil.
+func (h *Hub) subscribe(ctx context.Context, res chan<- *Update, topics []string, filter func(*Update) *Update) error {
+	sub := &hubSubscriber{
+		updates: make(chan *Update, hubBufferSize),
+	}
+	h.add(sub, topics)
+	defer h.remove(sub, topics)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case u := <-sub.updates:
+			if filter != nil {
+				u = filter(u)
+				if u == nil {
+					continue
+				}
+			}
+			select {
+			case res <- u:
+			case <-ctx.Done():
+				return nil
+			}
+		}
+	}
+}
+
+func (h *Hub) add(sub *hubSubscriber, topics []string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, topic := range topics {
+		if h.topics[topic] == nil {
+			h.topics[topic] = map[*hubSubscriber]struct{}{}
+		}
+		h.topics[topic][sub] = struct{}{}
+	}
+}
+
+func (h *Hub) remove(sub *hubSubscriber, topics []string) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for _, topic := range topics {
+		delete(h.topics[topic], sub)
+		if len(h.topics[topic]) == 0 {
+			delete(h.topics, topic)
+		}
+	}
+}
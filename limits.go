@@ -0,0 +1,169 @@
+package guiapi
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	// errInvalidRequest is returned when a request can't be decoded.
+	errInvalidRequest = errors.New("invalid request")
+	// errTooLarge is returned when a request body is larger than Options.MaxBodySize.
+	errTooLarge = errors.New("the request is too large")
+	// errTooDeep is returned when a JSON message is nested deeper than Options.MaxJSONDepth.
+	errTooDeep = fmt.Errorf("%w: nested too deeply", errInvalidRequest)
+	// errRateLimited is returned when a connection sent too many messages
+	// or a session runs too many actions at the same time.
+	errRateLimited = errors.New("too many requests, please try again later")
+)
+
+// SessionKey returns the key that identifies the session of a request,
+// for example the session cookie or the user ID. It is used to limit
+// the number of concurrent actions per session.
+type SessionKey func(r *http.Request) string
+
+// defaultSessionKey identifies the session by the CSRF cookie, which every
+// browser gets with the first page, and falls back to the IP address of the
+// client. Users behind the same proxy or NAT share an IP address, so it
+// would limit all of them together.
+func defaultSessionKey(r *http.Request) string {
+	if token, ok := csrfCookie(r); ok {
+		return "csrf:" + token
+	}
+	return "ip:" + remoteIP(r)
+}
+
+// remoteIP returns the IP address of the client.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// checkJSONDepth returns errTooDeep if the JSON value in buf contains
+// more than max nested objects or arrays. A max of 0 or less disables the check.
+// It doesn't validate the JSON, that is left to the decoder.
+func checkJSONDepth(buf []byte, max int) error {
+	if max <= 0 {
+		return nil
+	}
+	depth := 0
+	inString := false
+	escaped := false
+	for _, b := range buf {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case b == '\\':
+				escaped = true
+			case b == '"':
+				inString = false
+			}
+			continue
+		}
+		switch b {
+		case '"':
+			inString = true
+		case '{', '[':
+			depth++
+			if depth > max {
+				return errTooDeep
+			}
+		case '}', ']':
+			depth--
+		}
+	}
+	return nil
+}
+
+// rateLimiter is a token bucket that allows rate events per second
+// on average, with bursts of up to burst events. It is not safe for
+// concurrent use, every connection has its own rateLimiter.
+type rateLimiter struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// newRateLimiter returns a rateLimiter, or nil if rate is 0 or less.
+// A nil rateLimiter allows all events.
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:   rate,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// allow returns true if the event is allowed and takes a token from the bucket.
+func (l *rateLimiter) allow() bool {
+	if l == nil {
+		return true
+	}
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	if l.tokens < 1 {
+		return false
+	}
+	l.tokens--
+	return true
+}
+
+// actionLimiter limits the number of concurrently running actions per session.
+type actionLimiter struct {
+	lock    sync.Mutex
+	max     int
+	running map[string]int
+}
+
+// newActionLimiter returns an actionLimiter, or nil if max is 0 or less.
+func newActionLimiter(max int) *actionLimiter {
+	if max <= 0 {
+		return nil
+	}
+	return &actionLimiter{
+		max:     max,
+		running: map[string]int{},
+	}
+}
+
+// acquire returns false if the session already runs the maximum number of
+// actions. Otherwise it counts the action, which has to be released afterwards.
+func (l *actionLimiter) acquire(key string) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.running[key] >= l.max {
+		return false
+	}
+	l.running[key]++
+	return true
+}
+
+// release marks an action of the session as finished.
+func (l *actionLimiter) release(key string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	l.running[key]--
+	if l.running[key] <= 0 {
+		delete(l.running, key)
+	}
+}
@@ -0,0 +1,23 @@
+package guiapi
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDefaultSessionKey(t *testing.T) {
+	r := httptest.NewRequest("POST", "/guiapi", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	if key := defaultSessionKey(r); key != "ip:10.0.0.1" {
+		t.Errorf("got key %q without cookie, want the IP address", key)
+	}
+	other := httptest.NewRequest("POST", "/guiapi", nil)
+	other.RemoteAddr = "10.0.0.1:5678"
+	r.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: testCSRFToken})
+	other.AddCookie(&http.Cookie{Name: CSRFCookieName, Value: strings.Repeat("B", 43)})
+	if defaultSessionKey(r) == defaultSessionKey(other) {
+		t.Errorf("browsers behind the same IP address share the key %q", defaultSessionKey(r))
+	}
+}
@@ -0,0 +1,44 @@
+package guiapi
+
+import (
+	"context"
+	"time"
+)
+
+// Middleware wraps an ActionFunc with additional behavior, for example
+// authentication, logging or timing. The returned ActionFunc can inspect the
+// ActionCtx (including the Name of the called action) before calling next,
+// and the resulting Update and error afterwards. A Middleware can also
+// short-circuit the call by returning its own Update without calling next.
+type Middleware func(next ActionFunc) ActionFunc
+
+// Use adds global Middleware to the server that wraps every action call.
+// Global middleware is called in the order it was added, before any
+// middleware that was passed to AddAction().
+func (s *Server) Use(mw ...Middleware) {
+	s.middleware = append(s.middleware, mw...)
+}
+
+// chain wraps fn with the passed middleware, so that the
+// first middleware is the outermost one that gets called.
+func chain(fn ActionFunc, mw []Middleware) ActionFunc {
+	for i := len(mw) - 1; i >= 0; i-- {
+		fn = mw[i](fn)
+	}
+	return fn
+}
+
+// Timeout returns Middleware that cancels the context of the ActionCtx after
+// the duration d. If the action fails because of it, the browser gets an error
+// with the code "timeout". The global Options.ActionTimeout still applies,
+// so Timeout can only make the timeout of an action shorter.
+func Timeout(d time.Duration) Middleware {
+	return func(next ActionFunc) ActionFunc {
+		return func(c *ActionCtx) (*Update, error) {
+			ctx, cancel := context.WithTimeout(c.Context(), d)
+			defer cancel()
+			res, err := next(c.WithContext(ctx))
+			return res, timeoutErr(ctx, err)
+		}
+	}
+}
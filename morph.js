@@ -0,0 +1,170 @@
+// morph updates the element el in place, so that it matches the passed
+// HTML. Unlike replacing the outerHTML, elements that are still there keep
+// their focus, cursor position, scroll position, event listeners and the
+// values that the user entered. Children are matched by their id first,
+// and by their position otherwise. If the HTML doesn't consist of a single
+// element, el is replaced like with outerHTML.
+export function morph(el, html) {
+    const template = document.createElement("template")
+    template.innerHTML = html.trim()
+    const nodes = template.content.childNodes
+    if (nodes.length !== 1 || nodes[0].nodeType !== Node.ELEMENT_NODE) {
+        el.outerHTML = html
+        return
+    }
+    morphNode(el, nodes[0])
+}
+
+// morphNode updates from to match to, and returns the node that is
+// in the document afterwards. If the nodes are of a different type,
+// from gets replaced by to.
+function morphNode(from, to) {
+    if (from.nodeType !== to.nodeType || from.nodeName !== to.nodeName) {
+        from.replaceWith(to)
+        return to
+    }
+    if (from.nodeType !== Node.ELEMENT_NODE) {
+        if (from.nodeValue !== to.nodeValue) {
+            from.nodeValue = to.nodeValue
+        }
+        return from
+    }
+    if (!keepHydration(from, to)) {
+        from.replaceWith(to)
+        return to
+    }
+    // the input state is compared to the old attributes,
+    // so it needs to be synced before the attributes
+    syncInputState(from, to)
+    syncAttributes(from, to)
+    if (from.nodeName !== "TEXTAREA") {
+        morphChildren(from, to)
+    }
+    return from
+}
+
+// morphChildren updates the children of from to match the children of to.
+function morphChildren(from, to) {
+    const keyed = new Map()
+    for (const child of from.children) {
+        if (child.id) {
+            keyed.set(child.id, child)
+        }
+    }
+    let current = from.firstChild
+    for (const next of Array.from(to.childNodes)) {
+        let match = null
+        if (next.nodeType === Node.ELEMENT_NODE && next.id) {
+            const keyedMatch = keyed.get(next.id)
+            if (keyedMatch && keyedMatch.nodeName === next.nodeName) {
+                match = keyedMatch
+                keyed.delete(next.id)
+                if (match !== current) {
+                    from.insertBefore(match, current)
+                }
+            }
+        } else if (current && current.nodeType === next.nodeType &&
+            current.nodeName === next.nodeName && !current.id) {
+            // elements with an id are left for the matching
+            // new element, and are not reused for others
+            match = current
+        }
+        if (!match) {
+            from.insertBefore(next, current)
+            continue
+        }
+        const morphed = morphNode(match, next)
+        current = morphed.nextSibling
+    }
+    while (current) {
+        const remove = current
+        current = current.nextSibling
+        remove.remove()
+    }
+}
+
+// syncAttributes sets the attributes of from to the ones of to.
+function syncAttributes(from, to) {
+    for (const attr of Array.from(from.attributes)) {
+        if (!to.hasAttribute(attr.name)) {
+            from.removeAttribute(attr.name)
+        }
+    }
+    for (const attr of Array.from(to.attributes)) {
+        if (from.getAttribute(attr.name) !== attr.value) {
+            from.setAttribute(attr.name, attr.value)
+        }
+    }
+}
+
+// syncInputState only changes the value of inputs if the server sent a
+// different value than before, otherwise the user input is kept.
+function syncInputState(from, to) {
+    switch (from.nodeName) {
+        case "INPUT":
+            if (from.getAttribute("value") !== to.getAttribute("value")) {
+                setInputValue(from, to.getAttribute("value") || "")
+            }
+            if (from.hasAttribute("checked") !== to.hasAttribute("checked")) {
+                from.checked = to.hasAttribute("checked")
+            }
+            break
+        case "TEXTAREA":
+            if (from.defaultValue !== to.textContent) {
+                from.defaultValue = to.textContent
+                setInputValue(from, to.textContent)
+            }
+            break
+        case "OPTION":
+            if (from.hasAttribute("selected") !== to.hasAttribute("selected")) {
+                from.selected = to.hasAttribute("selected")
+            }
+            break
+    }
+}
+
+// setInputValue sets the value and keeps the cursor position
+// if the input is focused.
+function setInputValue(el, value) {
+    if (el.value === value) {
+        return
+    }
+    let start = null
+    let end = null
+    try {
+        start = el.selectionStart
+        end = el.selectionEnd
+    } catch (e) { }
+    el.value = value
+    if (el === document.activeElement && start !== null) {
+        try {
+            el.setSelectionRange(Math.min(start, value.length), Math.min(end, value.length))
+        } catch (e) { }
+    }
+}
+
+// hydrationAttributes are the attributes that are read when
+// an element is hydrated, see hydrate() in guiapi.js.
+const hydrationAttributes = ["ga-on", "ga-func", "ga-action", "ga-args", "ga-values", "ga-init", "ga-link", "href"]
+
+// keepHydration returns false if from was already hydrated and to needs
+// different event listeners or init functions, then from gets replaced.
+// If the hydration attributes are the same, from keeps its listeners and
+// the ga class is removed from to, so that it doesn't get hydrated twice.
+function keepHydration(from, to) {
+    const hydrated = !from.classList.contains("ga") &&
+        (from.hasAttribute("ga-on") || from.hasAttribute("ga-init") || from.hasAttribute("ga-link"))
+    if (!hydrated) {
+        return true
+    }
+    if (!to.classList.contains("ga")) {
+        return false
+    }
+    for (const name of hydrationAttributes) {
+        if (from.getAttribute(name) !== to.getAttribute(name)) {
+            return false
+        }
+    }
+    to.classList.remove("ga")
+    return true
+}
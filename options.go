@@ -0,0 +1,381 @@
+package guiapi
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"nhooyr.io/websocket"
+)
+
+// Options contains the configuration of a Server. The Options are passed
+// to New() either completely with WithOptions(), or field by field with
+// the other Option functions. Unset fields use the DefaultOptions().
+type Options struct {
+	// Logger is used for all log output of the server. Chatty messages,
+	// like every action call or WebSocket message, are logged on the debug level.
+	Logger *slog.Logger
+	// RedactArgs prevents the arguments of actions and streams from being
+	// logged, because they might contain user data.
+	RedactArgs bool
+
+	// BasePath is the URL path prefix that the server is mounted under,
+	// for example "/admin". The endpoints, Pages and Files are then served
+	// below this prefix, like "/admin/guiapi" for actions. Pages and Files
+	// are still registered with their paths relative to the base path.
+	//
+	// The browser needs to know the base path too. It can be passed to
+	// setupGuiapi() with the basePath option.
+	BasePath string
+	// ActionPath is the path of the action and page update endpoint.
+	ActionPath string
+	// WebsocketPath is the path of the stream WebSocket endpoint.
+	WebsocketPath string
+	// SSEPath is the path of the stream Server-Sent Events endpoint.
+	SSEPath string
+	// ErrorPath is the path of the endpoint that the browser reports
+	// errors to, for example missing targets of required HTML updates.
+	// If it is changed, it needs to be passed to setupGuiapi() with the
+	// errorURL option, like the paths of the other endpoints.
+	ErrorPath string
+
+	// MaxBodySize is the maximum size of an action request body in bytes.
+	// Larger requests are rejected with the error code "requestTooLarge".
+	// A negative value disables the limit.
+	MaxBodySize int64
+	// MaxMessageSize is the maximum size of a WebSocket message in bytes.
+	// Larger messages close the connection with the StatusMessageTooBig
+	// status. The default of 32 KiB is the same as the read limit of the
+	// WebSocket library. A negative value disables the limit.
+	MaxMessageSize int64
+	// MaxJSONDepth is the maximum nesting depth of objects and arrays in
+	// action requests and WebSocket messages. Deeper requests are rejected
+	// with the error code "invalidRequest". A negative value disables the limit.
+	MaxJSONDepth int
+	// MessageRate is the number of WebSocket messages per second that a
+	// connection can send on average, with bursts of up to MessageBurst
+	// messages. Messages above the rate get an error with the code
+	// "rateLimited". If it is 0, the rate is not limited.
+	MessageRate  float64
+	MessageBurst int
+	// ActionTimeout is the maximum duration of an action call, after which
+	// the context of the ActionCtx is canceled. Actions that fail because
+	// of it get an error with the code "timeout". If it is 0, there is no
+	// timeout. Single actions can use the Timeout() Middleware instead.
+	ActionTimeout time.Duration
+	// MaxConcurrentActions is the maximum number of actions that can run at
+	// the same time per session. Further actions get an error with the code
+	// "rateLimited". If it is 0, the number of actions is not limited.
+	MaxConcurrentActions int
+	// MaxSocketActions is the maximum number of actions that a single
+	// WebSocket connection can run at the same time. Further actions get an
+	// error with the code "rateLimited". The default is 16, a negative value
+	// disables the limit.
+	MaxSocketActions int
+	// SessionKey identifies the session of a request for MaxConcurrentActions.
+	// If it is nil, the CSRF cookie of the browser is used, or the IP address
+	// of the client if the request has no CSRF cookie.
+	SessionKey SessionKey
+
+	// NotFound handles requests that don't match any Page, File or endpoint.
+	// If it is nil, http.NotFound is used.
+	NotFound http.Handler
+	// MethodNotAllowed handles requests to a known path with the wrong method.
+	// If it is nil, a plain 405 Method Not Allowed response is sent.
+	MethodNotAllowed http.Handler
+
+	// Router is the HTTP router that all Pages, Files and endpoints get
+	// registered with. It can already contain other routes.
+	Router *httprouter.Router
+
+	// WebsocketAccept are the options for accepting WebSocket connections.
+	// The Subprotocols are always set to "guiapi".
+	WebsocketAccept websocket.AcceptOptions
+	// OriginPatterns are host patterns like "*.example.com" of other origins
+	// that are allowed to open stream connections. The host of the request
+	// is always allowed. The patterns are matched with filepath.Match().
+	// They don't allow actions: actions that are sent over the WebSocket
+	// connection of another origin are rejected unless it is listed in
+	// TrustedOrigins.
+	OriginPatterns []string
+	// StreamAuthorizer is called before a stream is started, and can deny it.
+	// Denied WebSocket streams get an error with the code "forbidden", while
+	// the other streams of the connection keep running. Denied Server-Sent
+	// Events requests get a 403 status.
+	StreamAuthorizer StreamAuthorizer
+
+	// StateCodec protects the State that is sent to the browser, for example
+	// by signing or encrypting it. If it is nil, the State is sent as is.
+	StateCodec StateCodec
+	// FlagInvalidState lets actions run even if the State that was sent
+	// from the browser was tampered with. ActionCtx.StateInvalid is set in
+	// this case. By default, such requests are rejected with an error.
+	FlagInvalidState bool
+
+	// DisableCSRF turns off the CSRF protection of the action endpoint. By
+	// default, action calls are only accepted from pages of the same origin
+	// that send the token from PageCtx.CSRFToken(). Actions that are called
+	// via the WebSocket connection are protected by its origin check instead.
+	DisableCSRF bool
+	// TrustedOrigins are additional origins like "https://example.com" that
+	// are allowed to call actions, for example if the server is behind a
+	// proxy that changes the Host header.
+	TrustedOrigins []string
+
+	// ErrorReporter gets called with internal errors like recovered panics
+	// or failed page updates, and with errors that the browser reported as
+	// *ClientError.
+	// If it is nil, the errors are logged with the Logger.
+	ErrorReporter ErrorReporter
+}
+
+// DefaultOptions returns the Options that are used by New()
+// for all fields that are not set.
+func DefaultOptions() Options {
+	return Options{
+		Logger:           slog.Default(),
+		ActionPath:       "/guiapi",
+		WebsocketPath:    "/guiapi/ws",
+		SSEPath:          "/guiapi/sse",
+		ErrorPath:        "/guiapi/error",
+		MaxBodySize:      1 << 20,
+		MaxMessageSize:   32 << 10,
+		MaxJSONDepth:     64,
+		MaxSocketActions: 16,
+		SessionKey:       defaultSessionKey,
+	}
+}
+
+// Option configures a Server that is created with New().
+type Option func(*Options)
+
+// WithOptions replaces all Options, fields that are
+// not set still use the DefaultOptions().
+func WithOptions(o Options) Option {
+	return func(opts *Options) {
+		*opts = o
+	}
+}
+
+// WithLogger sets the Logger that is used for all log output.
+func WithLogger(logger *slog.Logger) Option {
+	return func(opts *Options) {
+		opts.Logger = logger
+	}
+}
+
+// WithRedactArgs prevents the arguments of actions and
+// streams from being logged, because they might contain user data.
+func WithRedactArgs() Option {
+	return func(opts *Options) {
+		opts.RedactArgs = true
+	}
+}
+
+// WithBasePath mounts the server under the passed URL path prefix.
+// See Options.BasePath for more info.
+func WithBasePath(path string) Option {
+	return func(opts *Options) {
+		opts.BasePath = path
+	}
+}
+
+// WithPaths sets the paths of the action, WebSocket, Server-Sent Events and
+// error endpoints below the base path. Empty paths keep their default value.
+// If the paths are changed, they also need to be passed to setupGuiapi()
+// with the actionURL, websocketURL, sseURL and errorURL options.
+func WithPaths(action, websocket, sse, errorPath string) Option {
+	return func(opts *Options) {
+		opts.ActionPath = action
+		opts.WebsocketPath = websocket
+		opts.SSEPath = sse
+		opts.ErrorPath = errorPath
+	}
+}
+
+// WithMaxBodySize sets the maximum size of an action request body in bytes.
+func WithMaxBodySize(size int64) Option {
+	return func(opts *Options) {
+		opts.MaxBodySize = size
+	}
+}
+
+// WithMaxMessageSize sets the maximum size of a WebSocket message in bytes.
+func WithMaxMessageSize(size int64) Option {
+	return func(opts *Options) {
+		opts.MaxMessageSize = size
+	}
+}
+
+// WithMaxJSONDepth sets the maximum nesting depth of objects and
+// arrays in action requests and WebSocket messages.
+func WithMaxJSONDepth(depth int) Option {
+	return func(opts *Options) {
+		opts.MaxJSONDepth = depth
+	}
+}
+
+// WithMessageRate limits the number of WebSocket messages per second that a
+// connection can send on average, with bursts of up to burst messages.
+func WithMessageRate(rate float64, burst int) Option {
+	return func(opts *Options) {
+		opts.MessageRate = rate
+		opts.MessageBurst = burst
+	}
+}
+
+// WithActionTimeout sets the maximum duration of an action call.
+func WithActionTimeout(d time.Duration) Option {
+	return func(opts *Options) {
+		opts.ActionTimeout = d
+	}
+}
+
+// WithMaxConcurrentActions limits the number of actions that can run at the
+// same time per session. The session of a request is identified by the key
+// function, or by the CSRF cookie or IP address of the client if it is nil.
+func WithMaxConcurrentActions(max int, key SessionKey) Option {
+	return func(opts *Options) {
+		opts.MaxConcurrentActions = max
+		opts.SessionKey = key
+	}
+}
+
+// WithMaxSocketActions limits the number of actions that a single
+// WebSocket connection can run at the same time.
+func WithMaxSocketActions(max int) Option {
+	return func(opts *Options) {
+		opts.MaxSocketActions = max
+	}
+}
+
+// WithNotFound sets the handler for requests that don't
+// match any Page, File or endpoint.
+func WithNotFound(handler http.Handler) Option {
+	return func(opts *Options) {
+		opts.NotFound = handler
+	}
+}
+
+// WithMethodNotAllowed sets the handler for requests
+// to a known path with the wrong method.
+func WithMethodNotAllowed(handler http.Handler) Option {
+	return func(opts *Options) {
+		opts.MethodNotAllowed = handler
+	}
+}
+
+// WithRouter sets the HTTP router that all Pages, Files
+// and endpoints get registered with.
+func WithRouter(router *httprouter.Router) Option {
+	return func(opts *Options) {
+		opts.Router = router
+	}
+}
+
+// WithWebsocketAccept sets the options for accepting WebSocket connections.
+func WithWebsocketAccept(accept websocket.AcceptOptions) Option {
+	return func(opts *Options) {
+		opts.WebsocketAccept = accept
+	}
+}
+
+// WithOriginPatterns sets the host patterns of other origins
+// that are allowed to open stream connections.
+func WithOriginPatterns(patterns ...string) Option {
+	return func(opts *Options) {
+		opts.OriginPatterns = patterns
+	}
+}
+
+// WithStreamAuthorizer sets the StreamAuthorizer that
+// is called before a stream is started.
+func WithStreamAuthorizer(fn StreamAuthorizer) Option {
+	return func(opts *Options) {
+		opts.StreamAuthorizer = fn
+	}
+}
+
+// WithStateCodec sets the StateCodec that protects the State that is sent
+// to the browser, see NewSignedStateCodec() and NewEncryptedStateCodec().
+func WithStateCodec(codec StateCodec) Option {
+	return func(opts *Options) {
+		opts.StateCodec = codec
+	}
+}
+
+// WithFlagInvalidState lets actions run even if the State was tampered
+// with, and sets ActionCtx.StateInvalid instead of rejecting the request.
+func WithFlagInvalidState() Option {
+	return func(opts *Options) {
+		opts.FlagInvalidState = true
+	}
+}
+
+// WithoutCSRF turns off the CSRF protection of the action endpoint.
+// This should only be used if requests are protected by other means.
+func WithoutCSRF() Option {
+	return func(opts *Options) {
+		opts.DisableCSRF = true
+	}
+}
+
+// WithTrustedOrigins sets additional origins that are allowed to call actions.
+func WithTrustedOrigins(origins ...string) Option {
+	return func(opts *Options) {
+		opts.TrustedOrigins = origins
+	}
+}
+
+// WithErrorReporter sets the ErrorReporter that gets
+// called with internal errors like recovered panics.
+func WithErrorReporter(fn ErrorReporter) Option {
+	return func(opts *Options) {
+		opts.ErrorReporter = fn
+	}
+}
+
+// withDefaults fills all unset fields with the DefaultOptions().
+func (o Options) withDefaults() Options {
+	defaults := DefaultOptions()
+	if o.Logger == nil {
+		o.Logger = defaults.Logger
+	}
+	o.BasePath = strings.TrimSuffix(o.BasePath, "/")
+	if o.BasePath != "" && !strings.HasPrefix(o.BasePath, "/") {
+		o.BasePath = "/" + o.BasePath
+	}
+	if o.ActionPath == "" {
+		o.ActionPath = defaults.ActionPath
+	}
+	if o.WebsocketPath == "" {
+		o.WebsocketPath = defaults.WebsocketPath
+	}
+	if o.SSEPath == "" {
+		o.SSEPath = defaults.SSEPath
+	}
+	if o.ErrorPath == "" {
+		o.ErrorPath = defaults.ErrorPath
+	}
+	if o.MaxBodySize == 0 {
+		o.MaxBodySize = defaults.MaxBodySize
+	}
+	if o.MaxMessageSize == 0 {
+		o.MaxMessageSize = defaults.MaxMessageSize
+	}
+	if o.MaxJSONDepth == 0 {
+		o.MaxJSONDepth = defaults.MaxJSONDepth
+	}
+	if o.MaxSocketActions == 0 {
+		o.MaxSocketActions = defaults.MaxSocketActions
+	}
+	if o.SessionKey == nil {
+		o.SessionKey = defaults.SessionKey
+	}
+	if o.Router == nil {
+		o.Router = httprouter.New()
+	}
+	return o
+}
@@ -0,0 +1,79 @@
+package guiapi
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+)
+
+// PanicError is the error that gets reported to the ErrorReporter when a
+// panic was recovered while running an action, page or stream handler.
+type PanicError struct {
+	Source string // where the panic happened, for example `action "Counter.Increase"`
+	Value  any    // value that was passed to panic()
+	Stack  []byte // stack trace of the panicking goroutine
+}
+
+func (p *PanicError) Error() string {
+	return fmt.Sprintf("panic in %s: %v", p.Source, p.Value)
+}
+
+// ErrorReporter gets called with internal errors that happened while handling
+// a request, for example a recovered *PanicError, or with a *ClientError that
+// the browser reported. It can be used to forward these errors to a logging
+// or error tracking service.
+type ErrorReporter func(r *http.Request, err error)
+
+// SetErrorReporter replaces the ErrorReporter of the server. The default
+// ErrorReporter logs the errors and stack traces with the Logger.
+// It can also be set with the WithErrorReporter() Option.
+func (s *Server) SetErrorReporter(fn ErrorReporter) {
+	s.opts.ErrorReporter = fn
+}
+
+func (s *Server) logError(r *http.Request, err error) {
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		s.logger.Error("guiapi: recovered panic", "source", panicErr.Source, "panic", panicErr.Value, "stack", string(panicErr.Stack))
+		return
+	}
+	var clientErr *ClientError
+	if errors.As(err, &clientErr) {
+		s.logger.Warn("guiapi: client error", "code", clientErr.Code, "message", clientErr.Message,
+			"selector", clientErr.Selector, "page", clientErr.Page)
+		return
+	}
+	s.logger.Error("guiapi: internal error", "error", err)
+}
+
+func (s *Server) reportError(r *http.Request, err error) {
+	if s.opts.ErrorReporter == nil {
+		s.logError(r, err)
+		return
+	}
+	s.opts.ErrorReporter(r, err)
+}
+
+// safely calls fn and recovers any panic that happens during the call.
+// The panic is reported to the ErrorReporter and returned as a *PanicError.
+func (s *Server) safely(r *http.Request, source string, fn func() error) (err error) {
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		if v == http.ErrAbortHandler {
+			// this panic is used to abort a response on purpose
+			panic(v)
+		}
+		panicErr := &PanicError{
+			Source: source,
+			Value:  v,
+			Stack:  debug.Stack(),
+		}
+		s.reportError(r, panicErr)
+		err = panicErr
+	}()
+	return fn()
+}
@@ -2,10 +2,12 @@ package guiapi
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"sync/atomic"
 
 	"github.com/julienschmidt/httprouter"
 )
@@ -14,35 +16,82 @@ import (
 // It implements the http.Handler interface, so it can be directly passed
 // to a function like http.ListenAndServe(). When a request comes in, the
 // server will handle GET requests for pages, POST requests for actions,
-// and WebSocket requests for streams.
+// and WebSocket or Server-Sent Events requests for streams.
 type Server struct {
 	httpRouter  *httprouter.Router
 	pagesRouter *httprouter.Router
 	actions     map[string]ActionFunc
 	streams     map[string]StreamFunc
+	middleware  []Middleware
+	hub         *Hub
+	opts        Options
+	logger      *slog.Logger
+	connIDs     atomic.Int64
+	limiter     *actionLimiter // nil if the concurrent actions are not limited
 }
 
 // New returns a new guiapi Server. After registering all the Pages, Actions, Files and Streams,
-// the server can be directly used as a http.Handler.
-func New() *Server {
+// the server can be directly used as a http.Handler. The server can be configured with
+// the passed Options, see the Options type for all available settings.
+func New(options ...Option) *Server {
+	var opts Options
+	for _, opt := range options {
+		opt(&opts)
+	}
+	opts = opts.withDefaults()
+
 	s := &Server{
-		httpRouter:  httprouter.New(),
+		httpRouter:  opts.Router,
 		pagesRouter: httprouter.New(),
 		actions:     map[string]ActionFunc{},
 		streams:     map[string]StreamFunc{},
+		hub:         NewHub(),
+		opts:        opts,
+		logger:      opts.Logger,
+		limiter:     newActionLimiter(opts.MaxConcurrentActions),
+	}
+	s.hub.SetLogger(opts.Logger)
+	if opts.NotFound != nil {
+		s.httpRouter.NotFound = opts.NotFound
+	}
+	if opts.MethodNotAllowed != nil {
+		s.httpRouter.MethodNotAllowed = opts.MethodNotAllowed
 	}
-	s.httpRouter.POST("/guiapi", s.withPageCtx(s.handle))
-	s.httpRouter.GET("/guiapi/ws", s.withPageCtx(s.websocketHandler))
+	s.httpRouter.POST(opts.BasePath+opts.ActionPath, s.withPageCtx(s.handle))
+	s.httpRouter.GET(opts.BasePath+opts.WebsocketPath, s.withPageCtx(s.websocketHandler))
+	s.httpRouter.GET(opts.BasePath+opts.SSEPath, s.withPageCtx(s.sseHandler))
+	s.httpRouter.POST(opts.BasePath+opts.ErrorPath, s.withPageCtx(s.clientErrorHandler))
 
 	return s
 }
 
+// nextConnID returns a new ID that identifies a stream connection in the logs.
+func (s *Server) nextConnID() int64 {
+	return s.connIDs.Add(1)
+}
+
+// argsAttr returns the log attribute for action or stream arguments,
+// which are redacted if Options.RedactArgs is set.
+func (s *Server) argsAttr(args json.RawMessage) slog.Attr {
+	if s.opts.RedactArgs {
+		return slog.String("args", "[redacted]")
+	}
+	return slog.String("args", string(args))
+}
+
+// BasePath returns the URL path prefix that the server is mounted under,
+// or an empty string if it is mounted at the root. See Options.BasePath.
+func (s *Server) BasePath() string {
+	return s.opts.BasePath
+}
+
 func (s *Server) withPageCtx(handler func(*PageCtx)) httprouter.Handle {
 	return func(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 		c := &PageCtx{
 			Writer:  w,
 			Request: r,
 			Params:  ps,
+			server:  s,
 		}
 		handler(c)
 	}
@@ -62,12 +111,14 @@ func (s *Server) AddPage(path string, fn PageFunc) {
 // The files can also be in a subdirectory of the baseURL. This function is the
 // main way of serving static files from the guiapi server.
 func (s *Server) AddFiles(baseURL string, fs http.FileSystem) {
-	s.httpRouter.ServeFiles(baseURL+"*filepath", fs)
+	s.httpRouter.ServeFiles(s.opts.BasePath+baseURL+"*filepath", fs)
 }
 
 // AddAction registers an ActionFunc with the passed name and handler function on the server.
-func (s *Server) AddAction(name string, fn ActionFunc) {
-	s.actions[name] = fn
+// The optional middleware only wraps this action, inside of any global middleware
+// that was added with Use().
+func (s *Server) AddAction(name string, fn ActionFunc, mw ...Middleware) {
+	s.actions[name] = chain(fn, mw)
 }
 
 // Page gets returned from a PageFunc. The page needs to be able to
@@ -94,6 +145,9 @@ type PageCtx struct {
 	Writer  http.ResponseWriter
 	Request *http.Request
 	Params  httprouter.Params // params from placeholders in the URL
+
+	server    *Server
+	csrfToken string
 }
 
 // PageFunc is the page handler function that should return a Page value in
@@ -102,52 +156,98 @@ type PageCtx struct {
 type PageFunc func(*PageCtx) (Page, error)
 
 func (s *Server) pageHTML(path string, page PageFunc) {
-	s.httpRouter.GET(path, s.withPageCtx(func(c *PageCtx) {
-		res, err := page(c)
+	s.httpRouter.GET(s.opts.BasePath+path, s.withPageCtx(func(c *PageCtx) {
+		s.setCSRFCookie(c)
+		var res Page
+		err := s.safely(c.Request, fmt.Sprintf("page %q", path), func() error {
+			var err error
+			res, err = page(c)
+			return err
+		})
 		if err != nil {
-			log.Println("page error:", err)
-			http.Error(c.Writer, err.Error(), http.StatusInternalServerError)
+			s.logger.Warn("page error", "path", path, "error", err)
+			http.Error(c.Writer, pageErrorText(err), http.StatusInternalServerError)
 			return
 		}
-		err = res.WriteHTML(c.Writer)
+		err = s.safely(c.Request, fmt.Sprintf("page %q WriteHTML", path), func() error {
+			return res.WriteHTML(c.Writer)
+		})
 		if err != nil {
-			log.Println("page.HTML error:", err)
-			http.Error(c.Writer, err.Error(), http.StatusInternalServerError)
+			s.logger.Warn("page WriteHTML error", "path", path, "error", err)
+			http.Error(c.Writer, pageErrorText(err), http.StatusInternalServerError)
 			return
 		}
 	}))
 }
 
+// writePageError sends the error of a page update. Errors of the type *Error
+// are meant for the user and are sent like the errors of actions. All other
+// errors might contain internal details, so they are only reported, and the
+// browser gets an internal error.
+func (s *Server) writePageError(c *PageCtx, path string, err error) {
+	var e *Error
+	if errors.As(err, &e) {
+		res := &Update{Error: errorFromErr(err)}
+		s.writeUpdate(c.Writer, errorStatus(res.Error), res)
+		return
+	}
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) {
+		// panics were already reported by safely()
+		s.reportError(c.Request, fmt.Errorf("page %q: %w", path, err))
+	}
+	s.writeUpdate(c.Writer, http.StatusInternalServerError, &Update{Error: internalError()})
+}
+
+// pageErrorText returns the error text for a failed page request.
+// Recovered panics are not shown to the user.
+func pageErrorText(err error) string {
+	var panicErr *PanicError
+	if errors.As(err, &panicErr) {
+		return "Internal Server Error"
+	}
+	return err.Error()
+}
+
 func (s *Server) pageUpdate(path string, page PageFunc) {
 	s.pagesRouter.GET(path, s.withPageCtx(func(c *PageCtx) {
-		res, err := page(c)
-		if err != nil {
-			log.Println("page error:", err)
-			http.Error(c.Writer, "Internal Server Error", http.StatusInternalServerError)
-			return
-		}
-		updater, ok := res.(UpdateablePage)
-		if !ok {
-			err := fmt.Sprintf("page %q is not updateable", path)
-			log.Println(err)
-			http.Error(c.Writer, err, http.StatusNotImplemented)
+		s.setCSRFCookie(c)
+		var resp *Update
+		err := s.safely(c.Request, fmt.Sprintf("page %q", path), func() error {
+			res, err := page(c)
+			if err != nil {
+				return err
+			}
+			updater, ok := res.(UpdateablePage)
+			if !ok {
+				return errNotUpdateable
+			}
+			resp, err = updater.Update()
+			return err
+		})
+		if errors.Is(err, errNotUpdateable) {
+			s.logger.Warn("page is not updateable", "path", path)
+			s.writeError(c.Writer, err)
 			return
 		}
-		resp, err := updater.Update()
 		if err != nil {
-			log.Println("page.Update error:", err)
-			http.Error(c.Writer, "Internal Server Error", http.StatusInternalServerError)
+			s.logger.Warn("page error", "path", path, "error", err)
+			s.writePageError(c, path, err)
 			return
 		}
-		err = json.NewEncoder(c.Writer).Encode(resp)
-		if err != nil {
-			log.Println("write error:", err)
-			http.Error(c.Writer, "Internal Server Error", http.StatusInternalServerError)
-			return
+		if resp == nil {
+			resp = &Update{}
 		}
+		resp = s.encodeState(c.Request, resp)
+		s.writeUpdate(c.Writer, errorStatus(resp.Error), resp)
 	}))
 }
 
+var (
+	errNotUpdateable = errors.New("page is not updateable")
+	errPageNotFound  = errors.New("page not found")
+)
+
 // ServeHTTP implements the http.Handler interface. This means that the Server
 // can directly passed to a function like http.ListenAndServe().
 func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -166,3 +266,15 @@ func (s *Server) Router() *httprouter.Router {
 func (s *Server) AddStream(name string, fn StreamFunc) {
 	s.streams[name] = fn
 }
+
+// Hub returns the Hub of the server. Streams can subscribe to its topics
+// with Hub().Subscribe(), and Updates can be sent to them with Publish().
+func (s *Server) Hub() *Hub {
+	return s.hub
+}
+
+// Publish sends the Update to all streams that subscribed
+// to the topic on the Hub of the server.
+func (s *Server) Publish(topic string, u *Update) {
+	s.hub.Publish(topic, u)
+}
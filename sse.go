@@ -0,0 +1,105 @@
+package guiapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// sseKeepAlive is the interval in which comments are sent
+// to keep idle Server-Sent Events connections open.
+const sseKeepAlive = 30 * time.Second
+
+// sseHandler runs a single stream and sends its updates as Server-Sent Events.
+// It is the fallback for browsers that can't open a WebSocket connection.
+// The stream is selected with the query parameters id, name and args, where
+// args is the JSON encoded stream arguments. Every event contains the same
+// JSON message that would be sent via the WebSocket connection.
+func (s *Server) sseHandler(c *PageCtx) {
+	flusher, ok := c.Writer.(http.Flusher)
+	if !ok {
+		s.logger.Error("sse: ResponseWriter doesn't support flushing")
+		http.Error(c.Writer, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	query := c.Request.URL.Query()
+	name := query.Get("name")
+	id, err := strconv.ParseInt(query.Get("id"), 10, 64)
+	if err != nil && query.Get("id") != "" {
+		http.Error(c.Writer, "invalid id", http.StatusBadRequest)
+		return
+	}
+	var args json.RawMessage
+	if query.Get("args") != "" {
+		args = json.RawMessage(query.Get("args"))
+		if !json.Valid(args) {
+			http.Error(c.Writer, "invalid args", http.StatusBadRequest)
+			return
+		}
+	}
+
+	err = s.checkOrigin(c.Request)
+	if err != nil {
+		s.logger.Warn("sse: origin not allowed", "error", err)
+		http.Error(c.Writer, "Forbidden", http.StatusForbidden)
+		return
+	}
+	err = s.authorizeStream(c, name, args)
+	if err != nil {
+		http.Error(c.Writer, "Forbidden", http.StatusForbidden)
+		return
+	}
+
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("X-Accel-Buffering", "no")
+	c.Writer.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	// out is never closed, because the stream might still
+	// try to send on it after the handler returned
+	out := make(chan *streamMessage, 1)
+	logger := s.logger.With("conn", s.nextConnID(), "transport", "sse")
+	logger.Debug("start stream", "stream", name, "sub", id, s.argsAttr(args))
+	defer logger.Debug("exit stream", "stream", name, "sub", id)
+	go s.runStream(ctx, logger, c.Request, id, name, args, out)
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-keepAlive.C:
+			_, err := fmt.Fprint(c.Writer, ": keep-alive\n\n")
+			if err != nil {
+				logger.Debug("sse write error", "error", err)
+				return
+			}
+			flusher.Flush()
+		case msg := <-out:
+			buf, err := json.Marshal(msg)
+			if err != nil {
+				logger.Error("json marshal error", "error", err)
+				return
+			}
+			_, err = fmt.Fprintf(c.Writer, "data: %s\n\n", buf)
+			if err != nil {
+				logger.Debug("sse write error", "error", err)
+				return
+			}
+			flusher.Flush()
+			if msg.Done {
+				return
+			}
+		}
+	}
+}
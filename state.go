@@ -0,0 +1,226 @@
+package guiapi
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// ErrInvalidState is returned from a StateCodec if the State that was
+// sent back from the browser was tampered with or can't be decoded.
+var ErrInvalidState = errors.New("guiapi: invalid state")
+
+// StateCodec transforms the State of an Update before it is sent to the
+// browser, and transforms it back when it is sent with the next action or
+// page request. This way the State can be protected against tampering.
+// ActionFuncs always see the original JSON encoded State.
+//
+// A StateCodec is set with the WithStateCodec() Option.
+type StateCodec interface {
+	// Encode turns the JSON encoded State into the JSON value
+	// that is sent to the browser.
+	Encode(state json.RawMessage) (json.RawMessage, error)
+	// Decode turns the JSON value that was sent back from the browser into
+	// the original JSON encoded State. If the value was tampered with, it
+	// returns an error that wraps ErrInvalidState.
+	Decode(data json.RawMessage) (json.RawMessage, error)
+}
+
+const (
+	signedStatePrefix    = "s1."
+	encryptedStatePrefix = "e1."
+	// minSignedStateKey is the minimum key size for NewSignedStateCodec
+	minSignedStateKey = 32
+)
+
+// NewSignedStateCodec returns a StateCodec that signs the State with
+// HMAC-SHA256 and the passed key. The State is still readable in the
+// browser, but it can't be changed without being detected. The key
+// needs to be at least 32 random bytes and be the same on all instances.
+//
+// The signed State is not bound to a session and doesn't expire, so a
+// browser can send any State that it received earlier again.
+func NewSignedStateCodec(key []byte) (StateCodec, error) {
+	if len(key) < minSignedStateKey {
+		return nil, fmt.Errorf("guiapi: the state key has %d bytes, it needs at least %d", len(key), minSignedStateKey)
+	}
+	return &signedStateCodec{key: key}, nil
+}
+
+type signedStateCodec struct {
+	key []byte
+}
+
+func (c *signedStateCodec) Encode(state json.RawMessage) (json.RawMessage, error) {
+	payload := base64.RawURLEncoding.EncodeToString(state)
+	return json.Marshal(signedStatePrefix + payload + "." + c.sign(payload))
+}
+
+func (c *signedStateCodec) Decode(data json.RawMessage) (json.RawMessage, error) {
+	token, err := stateToken(data, signedStatePrefix)
+	if err != nil {
+		return nil, err
+	}
+	payload, mac, ok := strings.Cut(token, ".")
+	if !ok || !hmac.Equal([]byte(mac), []byte(c.sign(payload))) {
+		return nil, fmt.Errorf("%w: signature mismatch", ErrInvalidState)
+	}
+	state, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidState, err)
+	}
+	return state, nil
+}
+
+func (c *signedStateCodec) sign(payload string) string {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// NewEncryptedStateCodec returns a StateCodec that encrypts the State with
+// AES-GCM and the passed key, which needs to be 16, 24 or 32 bytes long.
+// The State can't be read or changed in the browser. The key should be
+// random and be the same on all instances.
+func NewEncryptedStateCodec(key []byte) (StateCodec, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &encryptedStateCodec{aead: aead}, nil
+}
+
+type encryptedStateCodec struct {
+	aead cipher.AEAD
+}
+
+// encryptedStateData is the additional data that is authenticated with the
+// encrypted State, so that other values encrypted with the key can't be used.
+var encryptedStateData = []byte("guiapi state")
+
+func (c *encryptedStateCodec) Encode(state json.RawMessage) (json.RawMessage, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	_, err := rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+	sealed := c.aead.Seal(nonce, nonce, state, encryptedStateData)
+	return json.Marshal(encryptedStatePrefix + base64.RawURLEncoding.EncodeToString(sealed))
+}
+
+func (c *encryptedStateCodec) Decode(data json.RawMessage) (json.RawMessage, error) {
+	token, err := stateToken(data, encryptedStatePrefix)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidState, err)
+	}
+	if len(sealed) < c.aead.NonceSize() {
+		return nil, fmt.Errorf("%w: too short", ErrInvalidState)
+	}
+	nonce, ciphertext := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	state, err := c.aead.Open(nil, nonce, ciphertext, encryptedStateData)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidState, err)
+	}
+	return state, nil
+}
+
+// stateToken returns the string token without the prefix from
+// the JSON encoded State that was sent from the browser.
+func stateToken(data json.RawMessage, prefix string) (string, error) {
+	var token string
+	err := json.Unmarshal(data, &token)
+	if err != nil {
+		return "", fmt.Errorf("%w: not a state token", ErrInvalidState)
+	}
+	if !strings.HasPrefix(token, prefix) {
+		return "", fmt.Errorf("%w: unknown state token format", ErrInvalidState)
+	}
+	return strings.TrimPrefix(token, prefix), nil
+}
+
+// hasState returns true if the JSON encoded State is not empty or null.
+func hasState(state json.RawMessage) bool {
+	state = bytes.TrimSpace(state)
+	return len(state) > 0 && !bytes.Equal(state, []byte("null"))
+}
+
+// decodeState decodes the State that was sent from the browser with the
+// StateCodec. If the State is invalid and Options.FlagInvalidState is set,
+// no error is returned and invalid is true instead. Other errors, like a
+// failing StateStore, are reported and errInternal is returned.
+func (s *Server) decodeState(r *http.Request, state json.RawMessage) (decoded json.RawMessage, invalid bool, err error) {
+	if s.opts.StateCodec == nil || !hasState(state) {
+		return state, false, nil
+	}
+	decoded, err = s.opts.StateCodec.Decode(state)
+	if err != nil {
+		if !errors.Is(err, ErrInvalidState) {
+			s.reportError(r, fmt.Errorf("decoding state: %w", err))
+			return nil, false, errInternal
+		}
+		s.logger.Warn("invalid state", "error", err)
+		if s.opts.FlagInvalidState {
+			return nil, true, nil
+		}
+		return nil, false, err
+	}
+	return decoded, false, nil
+}
+
+// encodeState returns a copy of the Update with the State replaced by the
+// value from the StateCodec. If this fails, the copy gets an internal error
+// instead. The passed Update is not changed, because stream Updates from
+// the Hub are shared by all subscribers.
+func (s *Server) encodeState(r *http.Request, u *Update) *Update {
+	if s.opts.StateCodec == nil || u == nil || u.State == nil {
+		return u
+	}
+	c := *u
+	encoded, err := s.EncodeState(u.State)
+	if err != nil {
+		s.reportError(r, fmt.Errorf("encoding state: %w", err))
+		c.State = nil
+		c.Error = internalError()
+		return &c
+	}
+	c.State = encoded
+	return &c
+}
+
+// EncodeState encodes the passed state value as JSON and with the StateCodec
+// of the server, if one is set. This is needed for the initial State that
+// is embedded in the HTML of a Page and passed to setupGuiapi().
+func (s *Server) EncodeState(state any) (json.RawMessage, error) {
+	buf, err := json.Marshal(state)
+	if err != nil {
+		return nil, err
+	}
+	if s.opts.StateCodec == nil {
+		return buf, nil
+	}
+	return s.opts.StateCodec.Encode(buf)
+}
+
+// EncodeState encodes the passed state value as JSON and with the StateCodec
+// of the server, if one is set. It should be used for the initial State that
+// is embedded in the HTML of a Page and passed to setupGuiapi().
+func (c *PageCtx) EncodeState(state any) (json.RawMessage, error) {
+	return c.server.EncodeState(state)
+}
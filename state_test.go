@@ -0,0 +1,132 @@
+package guiapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var testStateKey = []byte("0123456789abcdef0123456789abcdef")
+
+func testCodecs(t *testing.T) map[string]StateCodec {
+	t.Helper()
+	signed, err := NewSignedStateCodec(testStateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := NewEncryptedStateCodec(testStateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return map[string]StateCodec{"signed": signed, "encrypted": encrypted}
+}
+
+func TestStateCodecs(t *testing.T) {
+	state := json.RawMessage(`{"count":3}`)
+	for name, codec := range testCodecs(t) {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := codec.Encode(state)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := codec.Decode(encoded)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(decoded) != string(state) {
+				t.Errorf("decoded %s, want %s", decoded, state)
+			}
+
+			var token string
+			json.Unmarshal(encoded, &token)
+			// change one character of the payload
+			i := len(token) / 2
+			c := byte('A')
+			if token[i] == 'A' {
+				c = 'B'
+			}
+			tampered, _ := json.Marshal(token[:i] + string(c) + token[i+1:])
+			other, _ := json.Marshal("x1." + token[3:])
+			cases := map[string]json.RawMessage{
+				"tampered":       tampered,
+				"foreign prefix": other,
+				"not a string":   state,
+				"empty token":    json.RawMessage(`"` + token[:3] + `"`),
+			}
+			for name, data := range cases {
+				_, err := codec.Decode(data)
+				if !errors.Is(err, ErrInvalidState) {
+					t.Errorf("%s: got error %v, want ErrInvalidState", name, err)
+				}
+			}
+		})
+	}
+}
+
+func TestStateCodecForeignKey(t *testing.T) {
+	otherKey := []byte(strings.Repeat("k", 32))
+	signed, _ := NewSignedStateCodec(testStateKey)
+	otherSigned, _ := NewSignedStateCodec(otherKey)
+	encoded, err := otherSigned.Encode(json.RawMessage(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = signed.Decode(encoded)
+	if !errors.Is(err, ErrInvalidState) {
+		t.Errorf("got error %v for a state of another key, want ErrInvalidState", err)
+	}
+}
+
+func TestSignedStateCodecKeySize(t *testing.T) {
+	for _, key := range [][]byte{nil, []byte("short key"), testStateKey[:31]} {
+		_, err := NewSignedStateCodec(key)
+		if err == nil {
+			t.Errorf("key of %d bytes was accepted", len(key))
+		}
+	}
+}
+
+func TestFlagInvalidState(t *testing.T) {
+	codec, err := NewSignedStateCodec(testStateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := json.RawMessage(`"s1.e30.invalid"`)
+	cases := []struct {
+		name        string
+		options     []Option
+		wantCode    string
+		wantInvalid bool
+	}{
+		{name: "rejected", wantCode: "tamperedState"},
+		{name: "flagged", options: []Option{WithFlagInvalidState()}, wantInvalid: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := New(append(c.options, WithStateCodec(codec))...)
+			called, invalid := false, false
+			s.AddAction("Check", func(ac *ActionCtx) (*Update, error) {
+				called, invalid = true, ac.StateInvalid
+				return &Update{}, nil
+			})
+			p := &PageCtx{
+				Writer:  httptest.NewRecorder(),
+				Request: httptest.NewRequest("POST", "/guiapi", nil),
+				server:  s,
+			}
+			res := s.process(p, &action{Name: "Check", State: tampered})
+			code := ""
+			if res.Error != nil {
+				code = res.Error.Code
+			}
+			if code != c.wantCode {
+				t.Errorf("got error code %q, want %q", code, c.wantCode)
+			}
+			if called != (c.wantCode == "") || invalid != c.wantInvalid {
+				t.Errorf("action called %v with StateInvalid %v, want StateInvalid %v", called, invalid, c.wantInvalid)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package guiapi
+
+import (
+	"container/list"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrStateNotFound is returned from a StateStore if there is no State for a
+// token, because it expired or never existed. It wraps ErrInvalidState.
+var ErrStateNotFound = fmt.Errorf("%w: state not found", ErrInvalidState)
+
+// StateStore saves States on the server, so that only an opaque token has
+// to be sent to the browser. It is used with NewStoreStateCodec().
+type StateStore interface {
+	// Save stores the JSON encoded State under the token.
+	Save(token string, state json.RawMessage) error
+	// Load returns the State that was saved under the token. If there is no
+	// State for the token, it returns an error that wraps ErrStateNotFound.
+	Load(token string) (json.RawMessage, error)
+}
+
+const (
+	storedStatePrefix = "t1."
+	stateTokenSize    = 32
+	// defaultStateTTL is used by the StateStores if the ttl is not positive
+	defaultStateTTL = 24 * time.Hour
+	// defaultStateStoreSize is the default maximum size of a MemoryStateStore
+	defaultStateStoreSize = 64 << 20
+)
+
+// NewStoreStateCodec returns a StateCodec that saves the State in the passed
+// StateStore and only sends a token to the browser. This keeps large States
+// out of the requests, and the State can't be read or changed in the browser.
+// The token is derived from the State with a random key, so an unchanged
+// State keeps its token and is stored only once, while the States of pages
+// in the browser history stay valid until they expire from the store.
+func NewStoreStateCodec(store StateStore) StateCodec {
+	key := make([]byte, stateTokenSize)
+	_, err := rand.Read(key)
+	if err != nil {
+		// crypto/rand never fails on supported platforms
+		panic(err)
+	}
+	return &storeStateCodec{store: store, key: key}
+}
+
+type storeStateCodec struct {
+	store StateStore
+	key   []byte
+}
+
+func (c *storeStateCodec) Encode(state json.RawMessage) (json.RawMessage, error) {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write(state)
+	token := base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+	err := c.store.Save(token, state)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(storedStatePrefix + token)
+}
+
+func (c *storeStateCodec) Decode(data json.RawMessage) (json.RawMessage, error) {
+	token, err := stateToken(data, storedStatePrefix)
+	if err != nil {
+		return nil, err
+	}
+	// only tokens that were created by Encode are passed to the store,
+	// so that stores can use them as file names or keys directly
+	buf, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(buf) != stateTokenSize {
+		return nil, fmt.Errorf("%w: malformed token", ErrInvalidState)
+	}
+	return c.store.Load(token)
+}
+
+// MemoryStateStore is a StateStore that keeps the States in memory.
+// The States are lost when the process exits, and they are not shared
+// between server instances. If the States get larger than the maximum
+// size, the least recently used ones are removed.
+type MemoryStateStore struct {
+	lock    sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	size    int
+	states  map[string]*list.Element
+	// recent contains the memoryStates, the most recently used first
+	recent *list.List
+}
+
+type memoryState struct {
+	token   string
+	state   json.RawMessage
+	expires time.Time
+}
+
+// NewMemoryStateStore returns a new MemoryStateStore. States are
+// removed after they haven't been loaded for the ttl duration.
+// If the ttl is not positive, it defaults to 24 hours. The States
+// can use up to 64 MiB, which can be changed with SetMaxSize().
+func NewMemoryStateStore(ttl time.Duration) *MemoryStateStore {
+	if ttl <= 0 {
+		ttl = defaultStateTTL
+	}
+	return &MemoryStateStore{
+		ttl:     ttl,
+		maxSize: defaultStateStoreSize,
+		states:  map[string]*list.Element{},
+		recent:  list.New(),
+	}
+}
+
+// SetMaxSize sets the maximum size of all States in bytes. If the States
+// get larger, the least recently used ones are removed. A size of 0 or less
+// disables the limit.
+func (m *MemoryStateStore) SetMaxSize(size int) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.maxSize = size
+	m.evict(time.Now())
+}
+
+// Save stores the State under the token.
+func (m *MemoryStateStore) Save(token string, state json.RawMessage) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.maxSize > 0 && len(state) > m.maxSize {
+		return fmt.Errorf("guiapi: state of %d bytes is larger than the MemoryStateStore", len(state))
+	}
+	now := time.Now()
+	if el := m.states[token]; el != nil {
+		m.remove(el)
+	}
+	el := m.recent.PushFront(&memoryState{token: token, state: state, expires: now.Add(m.ttl)})
+	m.states[token] = el
+	m.size += len(state)
+	m.evict(now)
+	return nil
+}
+
+// Load returns the State that was saved under the token.
+func (m *MemoryStateStore) Load(token string) (json.RawMessage, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	now := time.Now()
+	el := m.states[token]
+	if el == nil {
+		return nil, ErrStateNotFound
+	}
+	s := el.Value.(*memoryState)
+	if now.After(s.expires) {
+		m.remove(el)
+		return nil, ErrStateNotFound
+	}
+	s.expires = now.Add(m.ttl)
+	m.recent.MoveToFront(el)
+	return s.state, nil
+}
+
+// evict removes expired States and the least recently used States until
+// they fit into the maximum size. All States have the same ttl, so the
+// expired ones are always the least recently used.
+func (m *MemoryStateStore) evict(now time.Time) {
+	for el := m.recent.Back(); el != nil; el = m.recent.Back() {
+		s := el.Value.(*memoryState)
+		if !now.After(s.expires) && (m.maxSize <= 0 || m.size <= m.maxSize) {
+			return
+		}
+		m.remove(el)
+	}
+}
+
+func (m *MemoryStateStore) remove(el *list.Element) {
+	s := m.recent.Remove(el).(*memoryState)
+	delete(m.states, s.token)
+	m.size -= len(s.state)
+}
+
+// FileStateStore is a StateStore that saves every State as a file in a
+// directory. The directory can be shared between server instances, for
+// example with a network file system.
+type FileStateStore struct {
+	dir string
+	ttl time.Duration
+
+	lock      sync.Mutex
+	lastSweep time.Time
+}
+
+// NewFileStateStore returns a new FileStateStore that saves the States in
+// the passed directory, which is created if it doesn't exist. States are
+// removed after they haven't been loaded for the ttl duration.
+// If the ttl is not positive, it defaults to 24 hours.
+func NewFileStateStore(dir string, ttl time.Duration) (*FileStateStore, error) {
+	if ttl <= 0 {
+		ttl = defaultStateTTL
+	}
+	err := os.MkdirAll(dir, 0o700)
+	if err != nil {
+		return nil, err
+	}
+	return &FileStateStore{
+		dir:       dir,
+		ttl:       ttl,
+		lastSweep: time.Now(),
+	}, nil
+}
+
+// Save stores the State under the token. The file is written to a temporary
+// file first, so that concurrent loads never see a partial State.
+func (f *FileStateStore) Save(token string, state json.RawMessage) error {
+	f.sweep()
+	tmp, err := os.CreateTemp(f.dir, ".tmp-*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(state)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	err = os.Rename(tmp.Name(), f.path(token))
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// Load returns the State that was saved under the token.
+func (f *FileStateStore) Load(token string) (json.RawMessage, error) {
+	path := f.path(token)
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrStateNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if now.Sub(info.ModTime()) > f.ttl {
+		os.Remove(path)
+		return nil, ErrStateNotFound
+	}
+	state, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrStateNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	// the modification time is used as the last access time
+	os.Chtimes(path, now, now)
+	return state, nil
+}
+
+func (f *FileStateStore) path(token string) string {
+	return filepath.Join(f.dir, filepath.Base(token)+".json")
+}
+
+// sweep removes all expired State files, but at most once per ttl duration.
+func (f *FileStateStore) sweep() {
+	f.lock.Lock()
+	now := time.Now()
+	if now.Sub(f.lastSweep) < f.ttl {
+		f.lock.Unlock()
+		return
+	}
+	f.lastSweep = now
+	f.lock.Unlock()
+
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || info.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		if !strings.HasSuffix(name, ".json") && !strings.HasPrefix(name, ".tmp-") {
+			continue
+		}
+		if now.Sub(info.ModTime()) > f.ttl {
+			os.Remove(filepath.Join(f.dir, name))
+		}
+	}
+}
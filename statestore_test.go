@@ -0,0 +1,142 @@
+package guiapi
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStoreStateCodec(t *testing.T) {
+	codec := NewStoreStateCodec(NewMemoryStateStore(time.Hour))
+	encoded, err := codec.Encode(json.RawMessage(`{"count":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := codec.Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(decoded) != `{"count":1}` {
+		t.Errorf("decoded %s, want {\"count\":1}", decoded)
+	}
+
+	again, err := codec.Encode(json.RawMessage(`{"count":1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(encoded) {
+		t.Errorf("unchanged state got a new token %s, want %s", again, encoded)
+	}
+	other, err := codec.Encode(json.RawMessage(`{"count":2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(other) == string(encoded) {
+		t.Errorf("changed state kept the token %s", other)
+	}
+}
+
+func TestStoreStateCodecMalformed(t *testing.T) {
+	codec := NewStoreStateCodec(NewMemoryStateStore(time.Hour))
+	unknown := `"t1.` + strings.Repeat("A", 43) + `"`
+	cases := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"not a string", `{"count":1}`, ErrInvalidState},
+		{"other prefix", `"s1.abc"`, ErrInvalidState},
+		{"invalid base64", `"t1.not base64!"`, ErrInvalidState},
+		{"short token", `"t1.AAAA"`, ErrInvalidState},
+		{"unknown token", unknown, ErrStateNotFound},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := codec.Decode(json.RawMessage(c.token))
+			if !errors.Is(err, c.want) {
+				t.Errorf("got error %v, want %v", err, c.want)
+			}
+		})
+	}
+}
+
+func TestMemoryStateStoreExpiry(t *testing.T) {
+	m := NewMemoryStateStore(100 * time.Millisecond)
+	m.Save("a", json.RawMessage(`1`))
+	m.Save("b", json.RawMessage(`2`))
+	time.Sleep(60 * time.Millisecond)
+	// loading extends the ttl
+	if _, err := m.Load("a"); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := m.Load("a"); err != nil {
+		t.Errorf("loaded state expired: %v", err)
+	}
+	if _, err := m.Load("b"); !errors.Is(err, ErrStateNotFound) {
+		t.Errorf("got error %v for expired state, want ErrStateNotFound", err)
+	}
+}
+
+func TestMemoryStateStoreMaxSize(t *testing.T) {
+	m := NewMemoryStateStore(time.Hour)
+	m.SetMaxSize(12)
+	m.Save("a", json.RawMessage(`"1234"`))
+	m.Save("b", json.RawMessage(`"1234"`))
+	m.Load("a")
+	m.Save("c", json.RawMessage(`"1234"`))
+	if _, err := m.Load("b"); !errors.Is(err, ErrStateNotFound) {
+		t.Errorf("least recently used state was not removed: %v", err)
+	}
+	for _, token := range []string{"a", "c"} {
+		if _, err := m.Load(token); err != nil {
+			t.Errorf("state %s was removed: %v", token, err)
+		}
+	}
+	if m.size != 12 {
+		t.Errorf("size is %d, want 12", m.size)
+	}
+	if err := m.Save("d", json.RawMessage(`"larger than the store"`)); err == nil {
+		t.Error("saved a state that is larger than the store")
+	}
+}
+
+func TestFileStateStoreExpiry(t *testing.T) {
+	dir := t.TempDir()
+	f, err := NewFileStateStore(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Save("a", json.RawMessage(`1`))
+	f.Save("b", json.RawMessage(`2`))
+	old := time.Now().Add(-2 * time.Hour)
+	os.Chtimes(filepath.Join(dir, "b.json"), old, old)
+
+	state, err := f.Load("a")
+	if err != nil || string(state) != "1" {
+		t.Errorf("got state %s and error %v, want 1", state, err)
+	}
+	if _, err := f.Load("b"); !errors.Is(err, ErrStateNotFound) {
+		t.Errorf("got error %v for expired state, want ErrStateNotFound", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "b.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expired state file was not removed: %v", err)
+	}
+}
+
+func TestStateStoreDefaultTTL(t *testing.T) {
+	if m := NewMemoryStateStore(0); m.ttl != defaultStateTTL {
+		t.Errorf("MemoryStateStore ttl is %v, want %v", m.ttl, defaultStateTTL)
+	}
+	f, err := NewFileStateStore(t.TempDir(), -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.ttl != defaultStateTTL {
+		t.Errorf("FileStateStore ttl is %v, want %v", f.ttl, defaultStateTTL)
+	}
+}
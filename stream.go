@@ -0,0 +1,80 @@
+package guiapi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/mbertschler/guiapi/api"
+)
+
+// StreamFunc is the type of a stream handler function. The initial arguments
+// from the client side are passed as JSON in args. Any time an update is
+// ready to be sent, it needs to be sent to the res channel. The stream can
+// be closed by returning from the function. If the client side closes the
+// connection or unsubscribes from the stream, the context will be canceled.
+// Because of this it is important to check ctx.Done() regularly.
+//
+// Multiple streams can run concurrently over a single connection.
+type StreamFunc func(ctx context.Context, args json.RawMessage, res chan<- *Update) error
+
+// streamMessage is sent from the server to the browser for every Update
+// of a stream subscription. The ID is chosen by the browser when subscribing.
+type streamMessage struct {
+	ID     int64   `json:"id"`
+	Update *Update `json:"update,omitempty"`
+	Done   bool    `json:"done,omitempty"` // the stream has ended
+}
+
+// runStream runs the StreamFunc with the passed name until it returns or
+// ctx is canceled. All updates from the stream are wrapped in streamMessages
+// with the subscription ID and sent to out. When the stream ends by itself,
+// a final message with Done set is sent, including an error if one occurred.
+func (s *Server) runStream(ctx context.Context, logger *slog.Logger, r *http.Request, id int64, name string, args json.RawMessage, out chan<- *streamMessage) {
+	send := func(msg *streamMessage) {
+		select {
+		case out <- msg:
+		case <-ctx.Done():
+		}
+	}
+
+	fn := s.streams[name]
+	if fn == nil {
+		logger.Warn("unknown stream", "stream", name, "sub", id)
+		send(&streamMessage{ID: id, Done: true, Update: &Update{Error: &api.Error{
+			Code:    "undefinedStream",
+			Message: fmt.Sprint(name, " is not defined"),
+		}}})
+		return
+	}
+
+	// res is unbuffered and never closed, so that every update that was
+	// sent by the stream is forwarded before the final Done message
+	res := make(chan *Update)
+	errs := make(chan error, 1)
+	go func() {
+		errs <- s.safely(r, fmt.Sprintf("stream %q", name), func() error {
+			return fn(ctx, args, res)
+		})
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case update := <-res:
+			update = s.encodeState(r, update)
+			send(&streamMessage{ID: id, Update: update})
+		case err := <-errs:
+			msg := &streamMessage{ID: id, Done: true}
+			if err != nil {
+				logger.Warn("stream error", "stream", name, "sub", id, "error", err)
+				msg.Update = &Update{Error: errorFromErr(err)}
+			}
+			send(msg)
+			return
+		}
+	}
+}
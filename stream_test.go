@@ -0,0 +1,68 @@
+package guiapi
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestStreamStateSharedUpdate checks that an Update that the Hub delivers to
+// several streams is not changed when its State is encoded for each of them.
+func TestStreamStateSharedUpdate(t *testing.T) {
+	codec, err := NewSignedStateCodec(testStateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := New(WithStateCodec(codec))
+	s.AddStream("Shared", func(ctx context.Context, args json.RawMessage, res chan<- *Update) error {
+		return s.Hub().Subscribe(ctx, res, "shared")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := httptest.NewRequest("GET", "/guiapi/ws", nil)
+	outs := []chan *streamMessage{make(chan *streamMessage), make(chan *streamMessage)}
+	for i, out := range outs {
+		go s.runStream(ctx, slog.Default(), r, int64(i+1), "Shared", nil, out)
+	}
+	waitForSubscribers(t, s.Hub(), "shared", len(outs))
+
+	u := &Update{State: map[string]int{"count": 3}}
+	s.Publish("shared", u)
+	for _, out := range outs {
+		var msg *streamMessage
+		select {
+		case msg = <-out:
+		case <-time.After(time.Second):
+			t.Fatal("no update received")
+		}
+		decoded, err := codec.Decode(msg.Update.State.(json.RawMessage))
+		if err != nil {
+			t.Fatalf("decoding state: %v", err)
+		}
+		if string(decoded) != `{"count":3}` {
+			t.Errorf("got state %s, want {\"count\":3}", decoded)
+		}
+	}
+	if _, ok := u.State.(map[string]int); !ok {
+		t.Errorf("published Update was changed, State is %T", u.State)
+	}
+}
+
+func waitForSubscribers(t *testing.T, h *Hub, topic string, n int) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		h.lock.Lock()
+		count := len(h.topics[topic])
+		h.lock.Unlock()
+		if count == n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("expected %d subscribers for %q", n, topic)
+}
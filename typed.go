@@ -0,0 +1,53 @@
+package guiapi
+
+import (
+	"encoding/json"
+)
+
+// TypedActionFunc is an action handler function with typed arguments and
+// state. The Args and State JSON that were sent from the browser are decoded
+// into the passed values before the function is called.
+type TypedActionFunc[Args, State any] func(c *ActionCtx, args *Args, state *State) (*Update, error)
+
+// TypedAction wraps a TypedActionFunc into a regular ActionFunc, so that it
+// can be registered with AddAction(). If the Args or State can't be decoded,
+// the returned Update contains an Error with the code "invalidArgs" or
+// "invalidState". If the returned Update has no State set, the state value is
+// encoded and sent back to the browser, so changes to it are kept.
+func TypedAction[Args, State any](fn TypedActionFunc[Args, State]) ActionFunc {
+	return func(c *ActionCtx) (*Update, error) {
+		var args Args
+		if len(c.Args) > 0 {
+			err := json.Unmarshal(c.Args, &args)
+			if err != nil {
+				return nil, Errorf("invalidArgs", "invalid args: %v", err).WithCause(err)
+			}
+		}
+
+		var state State
+		if len(c.State) > 0 {
+			err := json.Unmarshal(c.State, &state)
+			if err != nil {
+				return nil, Errorf("invalidState", "invalid state: %v", err).WithCause(err)
+			}
+		}
+
+		res, err := fn(c, &args, &state)
+		if err != nil {
+			return res, err
+		}
+		if res == nil {
+			res = &Update{}
+		}
+		if res.State == nil {
+			res.State = &state
+		}
+		return res, nil
+	}
+}
+
+// AddTypedAction registers a TypedActionFunc with the passed name on the server.
+// It is a shorthand for s.AddAction(name, TypedAction(fn), mw...).
+func AddTypedAction[Args, State any](s *Server, name string, fn TypedActionFunc[Args, State], mw ...Middleware) {
+	s.AddAction(name, TypedAction(fn), mw...)
+}
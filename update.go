@@ -13,6 +13,41 @@ type Update struct {
 	JS     []api.JSCall     `json:",omitempty"` // JS calls to execute
 	State  any              `json:",omitempty"` // State to pass back to the browser
 	Stream []api.Stream     `json:",omitempty"` // Stream to subscribe to via websocket
+	Title  string           `json:",omitempty"` // document title to set
+	Meta   []api.MetaTag    `json:",omitempty"` // meta tags to set in the document head
+
+	// Navigate is the navigation that the browser does after the Update was applied
+	Navigate *api.Navigation `json:",omitempty"`
+}
+
+// empty returns true if the Update doesn't change anything in the browser.
+func (u *Update) empty() bool {
+	return u.Name == "" && u.URL == "" && u.Error == nil && len(u.HTML) == 0 &&
+		len(u.JS) == 0 && u.State == nil && len(u.Stream) == 0 &&
+		u.Title == "" && len(u.Meta) == 0 && u.Navigate == nil
+}
+
+// All changes the HTML update that was added last, so that it gets applied
+// to all elements that match its selector, instead of only the first one.
+// It returns the Update, so it can be chained to the builder functions:
+//
+//	guiapi.ReplaceContent(".unread-count", "3").All()
+func (u *Update) All() *Update {
+	if len(u.HTML) > 0 {
+		u.HTML[len(u.HTML)-1].All = true
+	}
+	return u
+}
+
+// Required changes the HTML update that was added last, so that the browser
+// reports an error to the server if no element matches its selector. The
+// error is passed to the ErrorReporter as a *ClientError. Without it,
+// the browser only logs a warning to the console.
+func (u *Update) Required() *Update {
+	if len(u.HTML) > 0 {
+		u.HTML[len(u.HTML)-1].Required = true
+	}
+	return u
 }
 
 // JSCall returns a new Update that will call the registered JavaScript
@@ -81,7 +116,7 @@ func ReplaceElement(selector, content string) *Update {
 	return u
 }
 
-// AddReplaceElement adds a HTML Update that replaces the whole element
+// AddReplaceElement adds a HTML update that replaces the whole element
 // that gets selected by the passed selector with the HTML content.
 // The selector gets passed to document.querySelector,
 // so it can be any valid CSS selector.
@@ -132,3 +167,310 @@ func (u *Update) AddInsertAfter(selector, content string) {
 		Content:   content,
 	})
 }
+
+// RemoveElement returns a new Update that removes the element
+// that gets selected by the passed selector from the page.
+func RemoveElement(selector string) *Update {
+	u := &Update{}
+	u.AddRemoveElement(selector)
+	return u
+}
+
+// AddRemoveElement adds a HTML update that removes the element
+// that gets selected by the passed selector from the page.
+func (u *Update) AddRemoveElement(selector string) {
+	u.HTML = append(u.HTML, api.HTMLUpdate{
+		Operation: api.HTMLRemove,
+		Selector:  selector,
+	})
+}
+
+// AppendContent returns a new Update that inserts the HTML content as the
+// last children of the element that gets selected by the passed selector.
+func AppendContent(selector, content string) *Update {
+	u := &Update{}
+	u.AddAppendContent(selector, content)
+	return u
+}
+
+// AddAppendContent adds a HTML update that inserts the HTML content as the
+// last children of the element that gets selected by the passed selector.
+func (u *Update) AddAppendContent(selector, content string) {
+	u.HTML = append(u.HTML, api.HTMLUpdate{
+		Operation: api.HTMLAppend,
+		Selector:  selector,
+		Content:   content,
+	})
+}
+
+// PrependContent returns a new Update that inserts the HTML content as the
+// first children of the element that gets selected by the passed selector.
+func PrependContent(selector, content string) *Update {
+	u := &Update{}
+	u.AddPrependContent(selector, content)
+	return u
+}
+
+// AddPrependContent adds a HTML update that inserts the HTML content as the
+// first children of the element that gets selected by the passed selector.
+func (u *Update) AddPrependContent(selector, content string) {
+	u.HTML = append(u.HTML, api.HTMLUpdate{
+		Operation: api.HTMLPrepend,
+		Selector:  selector,
+		Content:   content,
+	})
+}
+
+// SetAttribute returns a new Update that sets the attribute with the passed
+// name to the value on the element that gets selected by the passed selector.
+func SetAttribute(selector, name, value string) *Update {
+	u := &Update{}
+	u.AddSetAttribute(selector, name, value)
+	return u
+}
+
+// AddSetAttribute adds a HTML update that sets the attribute with the passed
+// name to the value on the element that gets selected by the passed selector.
+func (u *Update) AddSetAttribute(selector, name, value string) {
+	u.HTML = append(u.HTML, api.HTMLUpdate{
+		Operation: api.HTMLSetAttribute,
+		Selector:  selector,
+		Name:      name,
+		Content:   value,
+	})
+}
+
+// RemoveAttribute returns a new Update that removes the attribute with the
+// passed name from the element that gets selected by the passed selector.
+func RemoveAttribute(selector, name string) *Update {
+	u := &Update{}
+	u.AddRemoveAttribute(selector, name)
+	return u
+}
+
+// AddRemoveAttribute adds a HTML update that removes the attribute with the
+// passed name from the element that gets selected by the passed selector.
+func (u *Update) AddRemoveAttribute(selector, name string) {
+	u.HTML = append(u.HTML, api.HTMLUpdate{
+		Operation: api.HTMLRemoveAttribute,
+		Selector:  selector,
+		Name:      name,
+	})
+}
+
+// ClassAdd returns a new Update that adds the CSS class to the
+// element that gets selected by the passed selector.
+func ClassAdd(selector, class string) *Update {
+	u := &Update{}
+	u.AddClassAdd(selector, class)
+	return u
+}
+
+// AddClassAdd adds a HTML update that adds the CSS class to the
+// element that gets selected by the passed selector.
+func (u *Update) AddClassAdd(selector, class string) {
+	u.HTML = append(u.HTML, api.HTMLUpdate{
+		Operation: api.HTMLAddClass,
+		Selector:  selector,
+		Name:      class,
+	})
+}
+
+// ClassRemove returns a new Update that removes the CSS class from the
+// element that gets selected by the passed selector.
+func ClassRemove(selector, class string) *Update {
+	u := &Update{}
+	u.AddClassRemove(selector, class)
+	return u
+}
+
+// AddClassRemove adds a HTML update that removes the CSS class from the
+// element that gets selected by the passed selector.
+func (u *Update) AddClassRemove(selector, class string) {
+	u.HTML = append(u.HTML, api.HTMLUpdate{
+		Operation: api.HTMLRemoveClass,
+		Selector:  selector,
+		Name:      class,
+	})
+}
+
+// ClassToggle returns a new Update that adds the CSS class to the element
+// that gets selected by the passed selector, or removes it if it is already set.
+func ClassToggle(selector, class string) *Update {
+	u := &Update{}
+	u.AddClassToggle(selector, class)
+	return u
+}
+
+// AddClassToggle adds a HTML update that adds the CSS class to the element
+// that gets selected by the passed selector, or removes it if it is already set.
+func (u *Update) AddClassToggle(selector, class string) {
+	u.HTML = append(u.HTML, api.HTMLUpdate{
+		Operation: api.HTMLToggleClass,
+		Selector:  selector,
+		Name:      class,
+	})
+}
+
+// SetValue returns a new Update that sets the value of the form input
+// that gets selected by the passed selector. Checkboxes and radio buttons
+// get checked if the value is "true" or equal to their value attribute.
+func SetValue(selector, value string) *Update {
+	u := &Update{}
+	u.AddSetValue(selector, value)
+	return u
+}
+
+// AddSetValue adds a HTML update that sets the value of the form input
+// that gets selected by the passed selector. Checkboxes and radio buttons
+// get checked if the value is "true" or equal to their value attribute.
+func (u *Update) AddSetValue(selector, value string) {
+	u.HTML = append(u.HTML, api.HTMLUpdate{
+		Operation: api.HTMLSetValue,
+		Selector:  selector,
+		Content:   value,
+	})
+}
+
+// Morph returns a new Update that changes the element that gets selected
+// by the passed selector to match the HTML content. Unlike ReplaceElement,
+// the browser only patches the parts of the DOM that changed, so inputs
+// keep their focus, cursor position and unsaved values, and scrolled
+// elements keep their position. Children are matched by their id, so
+// elements in lists should have one. The content should have a single
+// root element, otherwise the element gets replaced like with ReplaceElement.
+func Morph(selector, content string) *Update {
+	u := &Update{}
+	u.AddMorph(selector, content)
+	return u
+}
+
+// AddMorph adds a HTML update that changes the element that gets selected
+// by the passed selector to match the HTML content, by only patching the
+// parts of the DOM that changed. See Morph() for details.
+func (u *Update) AddMorph(selector, content string) {
+	u.HTML = append(u.HTML, api.HTMLUpdate{
+		Operation: api.HTMLMorph,
+		Selector:  selector,
+		Content:   content,
+	})
+}
+
+// Navigate returns a new Update that makes the browser load the guiapi page
+// at the URL and push it to the history, like a click on a ga-link.
+func Navigate(url string) *Update {
+	u := &Update{}
+	u.AddNavigate(url)
+	return u
+}
+
+// AddNavigate makes the browser load the guiapi page at the URL after the
+// Update was applied, and push it to the history. An Update can only have
+// one navigation, so it replaces navigations that were added before.
+func (u *Update) AddNavigate(url string) {
+	u.Navigate = &api.Navigation{
+		Operation: api.NavigationLoad,
+		URL:       url,
+	}
+}
+
+// NavigateReplace returns a new Update that makes the browser load the guiapi
+// page at the URL and replace the current history entry with it. This is useful
+// after a form was submitted, so that the back button doesn't lead to the form.
+func NavigateReplace(url string) *Update {
+	u := &Update{}
+	u.AddNavigateReplace(url)
+	return u
+}
+
+// AddNavigateReplace makes the browser load the guiapi page at the URL after the
+// Update was applied, and replace the current history entry with it. An Update
+// can only have one navigation, so it replaces navigations that were added before.
+func (u *Update) AddNavigateReplace(url string) {
+	u.Navigate = &api.Navigation{
+		Operation: api.NavigationReplace,
+		URL:       url,
+	}
+}
+
+// ReplaceURL returns a new Update that replaces the URL of the current history
+// entry without loading a page. Unlike the URL field, which pushes a new
+// history entry, the back button then skips the previous URL.
+func ReplaceURL(url string) *Update {
+	u := &Update{}
+	u.AddReplaceURL(url)
+	return u
+}
+
+// AddReplaceURL replaces the URL of the current history entry without loading
+// a page. An Update can only have one navigation, so it replaces navigations
+// that were added before.
+func (u *Update) AddReplaceURL(url string) {
+	u.Navigate = &api.Navigation{
+		Operation: api.NavigationReplaceURL,
+		URL:       url,
+	}
+}
+
+// Redirect returns a new Update that makes the browser load the URL as
+// a full page, for example to go to a login page that is not part of guiapi.
+func Redirect(url string) *Update {
+	u := &Update{}
+	u.AddRedirect(url)
+	return u
+}
+
+// AddRedirect makes the browser load the URL as a full page after the Update
+// was applied. An Update can only have one navigation, so it replaces
+// navigations that were added before.
+func (u *Update) AddRedirect(url string) {
+	u.Navigate = &api.Navigation{
+		Operation: api.NavigationRedirect,
+		URL:       url,
+	}
+}
+
+// Reload returns a new Update that makes the browser reload the current page.
+func Reload() *Update {
+	u := &Update{}
+	u.AddReload()
+	return u
+}
+
+// AddReload makes the browser reload the current page after the Update was
+// applied. An Update can only have one navigation, so it replaces navigations
+// that were added before.
+func (u *Update) AddReload() {
+	u.Navigate = &api.Navigation{
+		Operation: api.NavigationReload,
+	}
+}
+
+// Title returns a new Update that sets the title of the document.
+func Title(title string) *Update {
+	u := &Update{}
+	u.SetTitle(title)
+	return u
+}
+
+// SetTitle sets the title of the document when the Update is applied.
+func (u *Update) SetTitle(title string) {
+	u.Title = title
+}
+
+// Meta returns a new Update that sets the content of the meta tag
+// with the name in the document head.
+func Meta(name, content string) *Update {
+	u := &Update{}
+	u.AddMeta(name, content)
+	return u
+}
+
+// AddMeta sets the content of the meta tag with the name in the
+// document head. The tag is created if it doesn't exist yet.
+func (u *Update) AddMeta(name, content string) {
+	u.Meta = append(u.Meta, api.MetaTag{
+		Name:    name,
+		Content: content,
+	})
+}
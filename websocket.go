@@ -3,146 +3,262 @@ package guiapi
 import (
 	"context"
 	"encoding/json"
-	"log"
-	"math/rand"
+	"math"
+	"net/http"
+	"slices"
 
 	"nhooyr.io/websocket"
 )
 
-// StreamFunc is the type of a stream handler function. The initial arguments
-// from the client side are passed as JSON in args. Any time an update is
-// ready to be sent, it needs to be sent to the res channel. The stream can
-// be closed by returning from the function. If the client side closes the
-// connection, the context will be canceled. Because of this it is important
-// to check ctx.Done() regularly.
-type StreamFunc func(ctx context.Context, args json.RawMessage, res chan<- *Update) error
-
+// websocketMessage is sent from the browser to subscribe to a stream, to
+// unsubscribe from a previously subscribed stream or to call an action.
 type websocketMessage struct {
-	Name string          `json:"name"`
-	Args json.RawMessage `json:"args"`
+	Type   string          `json:"type"` // "subscribe" (default), "unsubscribe" or "action"
+	ID     int64           `json:"id"`   // subscription or action call ID, chosen by the browser
+	Name   string          `json:"name"`
+	Args   json.RawMessage `json:"args"`
+	Action *action         `json:"action,omitempty"` // only set for the "action" type
+}
+
+// subscription is a running stream of a websocket connection.
+type subscription struct {
+	id     int64
+	cancel context.CancelFunc
 }
 
 func (s *Server) websocketHandler(c *PageCtx) {
-	streamID := rand.Intn(10000)
-	conn, err := websocket.Accept(c.Writer, c.Request, &websocket.AcceptOptions{
-		Subprotocols: []string{"guiapi"},
-	})
+	logger := s.logger.With("conn", s.nextConnID(), "transport", "websocket")
+	accept := s.opts.WebsocketAccept
+	accept.Subprotocols = []string{"guiapi"}
+	accept.OriginPatterns = append(slices.Clone(accept.OriginPatterns), s.opts.OriginPatterns...)
+	conn, err := websocket.Accept(c.Writer, c.Request, &accept)
 	if err != nil {
-		log.Println("websocket accept error:", err)
+		logger.Warn("websocket accept error", "error", err)
 		return
 	}
 	defer conn.Close(websocket.StatusInternalError, "exit")
+	if s.opts.MaxMessageSize > 0 {
+		conn.SetReadLimit(s.opts.MaxMessageSize)
+	} else {
+		// one byte is added to the limit internally
+		conn.SetReadLimit(math.MaxInt64 - 1)
+	}
 
 	if conn.Subprotocol() != "guiapi" {
-		log.Printf("websocket accept error: invalid subprotocol %q", conn.Subprotocol())
+		logger.Warn("websocket accept error: invalid subprotocol", "subprotocol", conn.Subprotocol())
 		return
 	}
 
+	// origins from OriginPatterns may only use streams, not call actions
+	actionErr := s.checkActionOrigin(c.Request)
+
 	ctx := c.Request.Context()
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	ch := make(chan *Update, 1)
-	defer close(ch)
+	// out is never closed, because stream goroutines might still
+	// try to send on it after the handler returned
+	out := make(chan *streamMessage, 1)
 
-	log.Println("start websocket", streamID)
+	logger.Debug("start websocket")
 
 	go func() {
-		defer log.Println("exit websocket writer", streamID)
+		defer logger.Debug("exit websocket writer")
 		for {
 			select {
 			case <-ctx.Done():
 				err := conn.Close(websocket.StatusNormalClosure, "done")
 				if err != nil {
-					log.Println("websocket close error:", err)
+					logger.Debug("websocket close error", "error", err)
 					return
 				}
 				return
-			case resp, ok := <-ch:
-				if !ok {
-					log.Println("websocket writer not ok", streamID)
-					return
-				}
-				buf, err := json.Marshal(resp)
+			case msg := <-out:
+				buf, err := json.Marshal(msg)
 				if err != nil {
-					log.Println("json marshal error:", err)
+					logger.Error("json marshal error", "error", err)
 					return
 				}
 
 				err = conn.Write(ctx, websocket.MessageText, buf)
 				if err != nil {
-					log.Println("websocket write error:", err)
+					logger.Debug("websocket write error", "error", err)
 					return
 				}
 			}
 		}
 	}()
 
+	// messages is never closed, like out, the reader stops
+	// sending when ctx is canceled instead
 	messages := make(chan []byte)
-	defer close(messages)
 
 	go func() {
-		defer log.Println("exit websocket reader", streamID)
+		defer logger.Debug("exit websocket reader")
 		for {
 			msgType, buf, err := conn.Read(ctx)
 			if err != nil {
 				if websocket.CloseStatus(err) == websocket.StatusGoingAway {
-					log.Println("websocket going away")
+					logger.Debug("websocket going away")
 				} else {
-					log.Println("websocket read error:", err, "CloseStatus:", websocket.CloseStatus(err))
+					logger.Debug("websocket read error", "error", err, "status", websocket.CloseStatus(err))
 				}
 				cancel()
 				return
 			}
 			if msgType != websocket.MessageText {
-				log.Println("websocket read error: invalid message type", msgType)
+				logger.Warn("websocket read error: invalid message type", "type", msgType)
+				cancel()
 				return
 			}
 			select {
 			case messages <- buf:
 			case <-ctx.Done():
-				log.Println("websocket reader blocked", streamID)
+				return
 			}
 		}
 	}()
 
-	var previousCancel context.CancelFunc
-	defer log.Println("exit websocketHandler", streamID)
+	subs := map[int64]*subscription{}
+	finished := make(chan *subscription)
+	limiter := newRateLimiter(s.opts.MessageRate, s.opts.MessageBurst)
+	// actionSlots limits the actions that run at the same time, it is nil
+	// if the limit is disabled
+	var actionSlots chan struct{}
+	if s.opts.MaxSocketActions > 0 {
+		actionSlots = make(chan struct{}, s.opts.MaxSocketActions)
+	}
+	defer logger.Debug("exit websocket")
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case buf, ok := <-messages:
-			if previousCancel != nil {
-				previousCancel()
-			}
-			if !ok {
-				log.Println("websocket router not ok", streamID)
-				return
+		case sub := <-finished:
+			if subs[sub.id] == sub {
+				delete(subs, sub.id)
 			}
+		case buf := <-messages:
 			var msg websocketMessage
-			err := json.Unmarshal(buf, &msg)
+			err := checkJSONDepth(buf, s.opts.MaxJSONDepth)
+			if err == nil {
+				err = json.Unmarshal(buf, &msg)
+			}
 			if err != nil {
-				log.Println("json unmarshal error:", err)
+				logger.Warn("websocket message: json unmarshal error", "error", err)
 				cancel()
 				break
 			}
-			log.Printf("websocket message %q %s", msg.Name, msg.Args)
-			subCtx, subCancel := context.WithCancel(ctx)
-			previousCancel = subCancel
-			go func() {
-				fn := s.streams[msg.Name]
-				if fn == nil {
-					log.Println("StreamRouter error: unknown stream", msg.Name, string(buf))
-					cancel()
-					return
+			logger.Debug("websocket message", "type", msg.Type, "sub", msg.ID, "stream", msg.Name, s.argsAttr(msg.Args))
+			// unsubscribing is always allowed, because it reduces the load
+			if msg.Type != "unsubscribe" && !limiter.allow() {
+				logger.Warn("websocket message: rate limited", "type", msg.Type, "sub", msg.ID)
+				select {
+				case out <- &streamMessage{ID: msg.ID, Done: true, Update: &Update{Error: errorFromErr(errRateLimited)}}:
+				case <-ctx.Done():
 				}
-				err := fn(subCtx, msg.Args, ch)
-				if err != nil {
-					log.Println("StreamRouter error:", err)
-					cancel()
+				break
+			}
+			switch msg.Type {
+			case "", "subscribe":
+				if previous := subs[msg.ID]; previous != nil {
+					previous.cancel()
+				}
+				subCtx, subCancel := context.WithCancel(ctx)
+				sub := &subscription{id: msg.ID, cancel: subCancel}
+				subs[msg.ID] = sub
+				go func() {
+					defer subCancel()
+					p := &PageCtx{
+						Writer:  &discardResponseWriter{},
+						Request: c.Request,
+						Params:  c.Params,
+						server:  s,
+					}
+					// a denied stream only ends this subscription, the
+					// other streams and actions of the connection go on
+					err := s.authorizeStream(p, msg.Name, msg.Args)
+					if err != nil {
+						select {
+						case out <- &streamMessage{ID: msg.ID, Done: true, Update: &Update{Error: errorFromErr(err)}}:
+						case <-ctx.Done():
+						}
+					} else {
+						s.runStream(subCtx, logger, c.Request, msg.ID, msg.Name, msg.Args, out)
+					}
+					select {
+					case finished <- sub:
+					case <-ctx.Done():
+					}
+				}()
+			case "action":
+				if msg.Action == nil || msg.Action.Name == "" {
+					logger.Warn("websocket message: action without name", "sub", msg.ID)
+					break
+				}
+				if actionErr != nil {
+					logger.Warn("websocket message: action from other origin", "origin", c.Request.Header.Get("Origin"))
+					select {
+					case out <- &streamMessage{ID: msg.ID, Done: true, Update: &Update{Error: errorFromErr(actionErr)}}:
+					case <-ctx.Done():
+					}
+					break
 				}
-			}()
+				if actionSlots != nil {
+					select {
+					case actionSlots <- struct{}{}:
+					default:
+						logger.Warn("websocket message: too many running actions", "sub", msg.ID)
+						select {
+						case out <- &streamMessage{ID: msg.ID, Done: true, Update: &Update{Error: errorFromErr(errRateLimited)}}:
+						case <-ctx.Done():
+						}
+						continue
+					}
+				}
+				go func() {
+					if actionSlots != nil {
+						defer func() { <-actionSlots }()
+					}
+					p := &PageCtx{
+						Writer:  &discardResponseWriter{},
+						Request: c.Request,
+						Params:  c.Params,
+						server:  s,
+					}
+					res := s.process(p, msg.Action)
+					select {
+					case out <- &streamMessage{ID: msg.ID, Update: res, Done: true}:
+					case <-ctx.Done():
+					}
+				}()
+			case "unsubscribe":
+				if sub := subs[msg.ID]; sub != nil {
+					sub.cancel()
+					delete(subs, msg.ID)
+				}
+			default:
+				logger.Warn("websocket message: unknown type", "type", msg.Type)
+			}
 		}
 	}
 }
+
+// discardResponseWriter is the ActionCtx.Writer of actions that are called
+// via the WebSocket connection. The HTTP response was already sent when the
+// connection was upgraded, so all headers and writes are discarded.
+type discardResponseWriter struct {
+	header http.Header
+}
+
+func (d *discardResponseWriter) Header() http.Header {
+	if d.header == nil {
+		d.header = http.Header{}
+	}
+	return d.header
+}
+
+func (d *discardResponseWriter) Write(buf []byte) (int, error) {
+	return len(buf), nil
+}
+
+func (d *discardResponseWriter) WriteHeader(int) {}
@@ -1,29 +1,116 @@
-import { handleResponse } from "./guiapi.js"
+import { handleResponse, timeoutError } from "./guiapi.js"
 
 class Stream {
-    constructor(url) {
+    constructor(url, sseURL) {
         this.url = url
+        this.sseURL = sseURL
         this.socket = null
         this.open = false
+        this.opened = false
         this.closed = false
-        this.current = null
+        this.subscriptions = new Map()
+        this.nextID = 1
         this.tries = 0
+        // if WebSockets are not available, every subscription
+        // uses its own Server-Sent Events EventSource
+        this.useSSE = false
+        this.sources = new Map()
+        // action calls that were sent via the WebSocket
+        // and are waiting for their response
+        this.pending = new Map()
     }
 
-    subscribe = (data) => {
-        console.log("Stream.subscribe()", data)
+    subscribe = (stream) => {
+        console.log("Stream.subscribe()", stream)
 
+        const id = this.nextID++
+        this.subscriptions.set(id, stream)
+        if (this.useSSE) {
+            this.openSource(id, stream)
+            return id
+        }
         this.tries = 0
         if (this.open) {
-            this.current = data
-            this.socket.send(JSON.stringify(data))
-            return
+            this.sendSubscribe(id, stream)
+            return id
         }
-
-        this.current = data
         if (!this.socket) {
             this.connect()
         }
+        return id
+    }
+
+    unsubscribe = (id) => {
+        console.log("Stream.unsubscribe()", id)
+
+        if (!this.subscriptions.delete(id)) {
+            return
+        }
+        if (this.useSSE) {
+            this.closeSource(id)
+            return
+        }
+        if (this.open) {
+            this.socket.send(JSON.stringify({ type: "unsubscribe", id }))
+        }
+    }
+
+    sendSubscribe = (id, stream) => {
+        this.socket.send(JSON.stringify({
+            type: "subscribe",
+            id,
+            name: stream.Name,
+            args: stream.Args,
+        }))
+    }
+
+    sendAction = (req, callback, timeout) => {
+        if (!this.open) {
+            return false
+        }
+        const id = this.nextID++
+        this.pending.set(id, callback)
+        this.socket.send(JSON.stringify({ type: "action", id, action: req }))
+        if (timeout > 0) {
+            setTimeout(() => {
+                // a late response is ignored, because it isn't pending anymore
+                if (this.pending.delete(id)) {
+                    handleResponse({ Error: timeoutError() }, callback)
+                }
+            }, timeout)
+        }
+        return true
+    }
+
+    switchToSSE = () => {
+        console.log("WebSocket unavailable, using Server-Sent Events:", this.sseURL)
+        this.useSSE = true
+        for (const [id, stream] of this.subscriptions) {
+            this.openSource(id, stream)
+        }
+    }
+
+    openSource = (id, stream) => {
+        const params = new URLSearchParams({ id, name: stream.Name })
+        if (stream.Args !== undefined && stream.Args !== null) {
+            params.set("args", JSON.stringify(stream.Args))
+        }
+        const source = new EventSource(this.sseURL + "?" + params.toString())
+        source.onmessage = (event) => {
+            this.handleMessage(JSON.parse(event.data))
+        }
+        source.onerror = (event) => {
+            console.log("EventSource error:", id, event)
+        }
+        this.sources.set(id, source)
+    }
+
+    closeSource = (id) => {
+        const source = this.sources.get(id)
+        if (source) {
+            source.close()
+            this.sources.delete(id)
+        }
     }
 
     connect = () => {
@@ -41,13 +128,37 @@ class Stream {
         console.log("websocket opened:", event);
         this.tries = 0
         this.open = true
-        this.socket.send(JSON.stringify(this.current))
+        this.opened = true
+        for (const [id, stream] of this.subscriptions) {
+            this.sendSubscribe(id, stream)
+        }
     }
 
     onmessage = (event) => {
-        const res = JSON.parse(event.data)
-        console.log("stream message:", res)
-        handleResponse(res, (err) => {
+        this.handleMessage(JSON.parse(event.data))
+    }
+
+    handleMessage = (msg) => {
+        console.log("stream message:", msg)
+        const callback = this.pending.get(msg.id)
+        if (callback) {
+            this.pending.delete(msg.id)
+            handleResponse(msg.update, callback)
+            return
+        }
+        if (!this.subscriptions.has(msg.id)) {
+            // the subscription was already unsubscribed
+            return
+        }
+        if (msg.done) {
+            this.subscriptions.delete(msg.id)
+            // an EventSource would reconnect and restart the stream
+            this.closeSource(msg.id)
+        }
+        if (!msg.update) {
+            return
+        }
+        handleResponse(msg.update, (err) => {
             if (err) {
                 console.error("websocket handleResponse error:", err)
             }
@@ -58,11 +169,25 @@ class Stream {
         this.open = false
         this.socket = null
         console.log("websocket closed:", event);
+        // it is unknown if pending actions were run, so they are not retried
+        for (const callback of this.pending.values()) {
+            handleResponse({
+                Error: {
+                    Code: "connectionClosed",
+                    Message: "the connection was closed before the action completed",
+                },
+            }, callback)
+        }
+        this.pending.clear()
         if (this.closed) {
             return
         }
         if (this.tries < 3) {
             this.connect()
+        } else if (!this.opened) {
+            // the WebSocket never opened, for example because
+            // a proxy strips the upgrade headers
+            this.switchToSSE()
         } else {
             console.log("stopped reconnecting after 3 tries")
         }
@@ -74,13 +199,76 @@ class Stream {
     }
 }
 
-const streamHandler = new Stream("ws://localhost:8000/guiapi/ws");
+// websocketURL returns the absolute WebSocket URL for the path on the host
+// of the current page. Pages that are served via https:// use wss://.
+export function websocketURL(path) {
+    const scheme = window.location.protocol === "https:" ? "wss:" : "ws:"
+    return scheme + "//" + window.location.host + path
+}
+
+const streamHandler = new Stream(websocketURL("/guiapi/ws"), "/guiapi/sse");
+
+// setStreamURLs sets the endpoints that are used for streams. It needs
+// to be called before the first stream is subscribed.
+export function setStreamURLs(websocket, sse) {
+    streamHandler.url = websocket
+    streamHandler.sseURL = sse
+}
+
+// pageStreams maps the streams of the current page to their subscription IDs.
+let pageStreams = new Map()
+
+// subscribe subscribes to the passed stream and returns the subscription ID,
+// which can be passed to unsubscribe. Any number of streams can be subscribed
+// at the same time.
+export function subscribe(stream) {
+    return streamHandler.subscribe(stream)
+}
+
+// unsubscribe stops the stream with the passed subscription ID.
+export function unsubscribe(id) {
+    streamHandler.unsubscribe(id)
+}
+
+// sendAction calls an action via the WebSocket connection. It returns
+// false if the connection is not open, in which case nothing was sent.
+// If timeout is set, the callback gets a timeout error after that
+// many milliseconds without a response.
+export function sendAction(req, callback, timeout) {
+    return streamHandler.sendAction(req, callback, timeout)
+}
+
+// handleStreams replaces the streams of the current page with the passed
+// streams. Streams that are already subscribed with the same name and
+// arguments keep running, all others get unsubscribed.
+export function handleStreams(streams) {
+    console.log("guiapi handleStreams:", streams)
+    const next = new Map()
+    for (const stream of streams) {
+        const key = JSON.stringify([stream.Name, stream.Args])
+        if (next.has(key)) {
+            continue
+        }
+        let id = pageStreams.get(key)
+        if (id === undefined || !streamHandler.subscriptions.has(id)) {
+            id = streamHandler.subscribe(stream)
+        }
+        pageStreams.delete(key)
+        next.set(key, id)
+    }
+    for (const id of pageStreams.values()) {
+        streamHandler.unsubscribe(id)
+    }
+    pageStreams = next
+}
 
 export function handleStream(stream) {
-    console.log("guiapi handleStream:", stream)
-    streamHandler.subscribe(stream)
+    handleStreams([stream])
 }
 
 export default {
-    handleStream
-}
\ No newline at end of file
+    handleStream,
+    handleStreams,
+    subscribe,
+    unsubscribe,
+}
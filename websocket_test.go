@@ -0,0 +1,132 @@
+package guiapi
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"nhooyr.io/websocket"
+)
+
+// testSocket is a WebSocket connection to a test server.
+type testSocket struct {
+	t    *testing.T
+	conn *websocket.Conn
+}
+
+func dialSocket(t *testing.T, s *Server) *testSocket {
+	t.Helper()
+	srv := httptest.NewServer(s)
+	t.Cleanup(srv.Close)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/guiapi/ws"
+	conn, _, err := websocket.Dial(ctx, url, &websocket.DialOptions{Subprotocols: []string{"guiapi"}})
+	if err != nil {
+		t.Fatalf("dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close(websocket.StatusNormalClosure, "") })
+	return &testSocket{t: t, conn: conn}
+}
+
+func (ts *testSocket) send(msg websocketMessage) {
+	ts.t.Helper()
+	buf, err := json.Marshal(msg)
+	if err != nil {
+		ts.t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = ts.conn.Write(ctx, websocket.MessageText, buf)
+	if err != nil {
+		ts.t.Fatalf("write: %v", err)
+	}
+}
+
+// receive returns the next message that the server sent.
+func (ts *testSocket) receive() *streamMessage {
+	ts.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	_, buf, err := ts.conn.Read(ctx)
+	if err != nil {
+		ts.t.Fatalf("read: %v", err)
+	}
+	var msg streamMessage
+	err = json.Unmarshal(buf, &msg)
+	if err != nil {
+		ts.t.Fatalf("decoding message %s: %v", buf, err)
+	}
+	return &msg
+}
+
+func errorCode(msg *streamMessage) string {
+	if msg.Update == nil || msg.Update.Error == nil {
+		return ""
+	}
+	return msg.Update.Error.Code
+}
+
+func TestWebsocketActionLimit(t *testing.T) {
+	s := New(WithMaxSocketActions(2))
+	release := make(chan struct{})
+	s.AddAction("Block", func(c *ActionCtx) (*Update, error) {
+		<-release
+		return &Update{}, nil
+	})
+	ts := dialSocket(t, s)
+
+	for id := int64(1); id <= 3; id++ {
+		ts.send(websocketMessage{Type: "action", ID: id, Action: &action{Name: "Block"}})
+	}
+	msg := ts.receive()
+	if msg.ID != 3 || !msg.Done || errorCode(msg) != "rateLimited" {
+		t.Fatalf("got message %d with error %q, want 3 with rateLimited", msg.ID, errorCode(msg))
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		msg := ts.receive()
+		if errorCode(msg) != "" {
+			t.Errorf("action %d failed with %q", msg.ID, errorCode(msg))
+		}
+	}
+	ts.send(websocketMessage{Type: "action", ID: 4, Action: &action{Name: "Block"}})
+	msg = ts.receive()
+	if msg.ID != 4 || errorCode(msg) != "" {
+		t.Errorf("got message %d with error %q, want 4 without error", msg.ID, errorCode(msg))
+	}
+}
+
+func TestWebsocketDeniedStream(t *testing.T) {
+	s := New(WithStreamAuthorizer(func(c *PageCtx, name string, args json.RawMessage) error {
+		if name == "Secret" {
+			return errors.New("not allowed")
+		}
+		return nil
+	}))
+	for _, name := range []string{"Public", "Secret"} {
+		s.AddStream(name, func(ctx context.Context, args json.RawMessage, res chan<- *Update) error {
+			return s.Hub().Subscribe(ctx, res, "updates")
+		})
+	}
+	ts := dialSocket(t, s)
+
+	ts.send(websocketMessage{Type: "subscribe", ID: 1, Name: "Public"})
+	waitForSubscribers(t, s.Hub(), "updates", 1)
+	ts.send(websocketMessage{Type: "subscribe", ID: 2, Name: "Secret"})
+	msg := ts.receive()
+	if msg.ID != 2 || !msg.Done || errorCode(msg) != "forbidden" {
+		t.Fatalf("got message %d with error %q, want 2 with forbidden", msg.ID, errorCode(msg))
+	}
+
+	s.Publish("updates", &Update{Title: "still running"})
+	msg = ts.receive()
+	if msg.ID != 1 || msg.Update == nil || msg.Update.Title != "still running" {
+		t.Errorf("got message %d with update %+v, want the update of stream 1", msg.ID, msg.Update)
+	}
+}